@@ -1,46 +1,92 @@
 package api
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/qiniu/log"
 	"github.com/qiniu/pandora-go-sdk/base"
 	"github.com/qiniu/pandora-go-sdk/logdb"
+	"github.com/qiniuts/qlogctl/query"
 )
 
 const (
-	DateLayout = "2006-01-02T15:04:05-0700"
+	DateLayout      = "2006-01-02T15:04:05-0700"
+	DateLayoutMilli = "2006-01-02T15:04:05.000-0700"
 )
 
 type CtlArg struct {
-	Fields     string     // 显示展示哪些字段，* 表示全部字段。字段名以逗号 , 分割，忽略空格
-	ShowIndex  bool       // 是否显示行号
-	Split      string     // 显示时，各字段的分割方式
-	DateField  string     //时间范围所作用的字段，如 timestamp
-	OrderField string     // 排序字段
-	OrderType  string     // 排序方式 desc 或 asc
-	Sort       string     // 最终排序参数
-	Start      *time.Time // 查询的起始时间
-	End        *time.Time // 查询的结束时间
-	PreSize    int        // 每次查询多少条
-	Scroll     bool       // 是否使用 scroll 方式拉取数据
-	fields     []logdb.RepoSchemaEntry
+	Fields       string        // 显示展示哪些字段，* 表示全部字段。字段名以逗号 , 分割，忽略空格
+	ShowIndex    bool          // 是否显示行号
+	Split        string        // 显示时，各字段的分割方式
+	DateField    string        //时间范围所作用的字段，如 timestamp
+	OrderField   string        // 排序字段
+	OrderType    string        // 排序方式 desc 或 asc
+	Sort         string        // 最终排序参数
+	Start        *time.Time    // 查询的起始时间
+	End          *time.Time    // 查询的结束时间
+	PreSize      int           // 每次查询多少条
+	Scroll       bool          // 是否使用 scroll 方式拉取数据
+	Progress     bool          // scroll 方式拉取时，是否在 stderr 显示进度
+	Count        bool          // 只查询满足条件的总条数
+	Explain      bool          // 只显示最终的查询参数，不查询数据
+	Limit        int           // 最多输出多少条，0 表示不限制
+	From         int           // 从第几条开始获取（偏移量），不能与 Scroll 同时使用
+	Yes          bool          // 总条数超过 Config.ConfirmThreshold 时不再询问，直接拉取
+	Prefetch     int           // scroll 方式拉取时，最多预取多少页
+	AutoSize     bool          // scroll 方式拉取时，根据每次查询的耗时自动调整每页条数
+	Target       time.Duration // AutoSize 时，每次查询的目标耗时
+	Strict       bool          // 部分分片查询失败 (PartialSuccess) 时报错退出
+	RetryPartial bool          // 部分分片查询失败时重新查询
+	Verify       bool          // 导出结束后，按时间分桶与服务端核对条数
+	Backfill     bool          // 核对不一致的桶重新拉取，补充输出缺少的记录
+	VerifyStep   time.Duration // 核对的分桶间隔，0 表示自动选择
+	Output       string        // 输出到文件，并在旁边生成 manifest ；为空时输出到 stdout
+	Follow       bool          // 持续查询新日志，类似 tail -f
+	FollowStep   time.Duration // Follow 时，每次查询的间隔
+	Lag          time.Duration // Follow 时，每次查询往前多查的时间，容忍延迟写入的日志
+	Format       string        // 输出格式 text json csv ，默认 text
+	NoCheck      bool          // 不在本地检查查询语句
+	StrictRange  bool          // 时间范围超出 repo 的保存时间时报错退出
+	ClampRange   bool          // 时间范围超出 repo 的保存时间时，将开始时间调整到保存时间内
+	Before       int           // 输出每条匹配的记录前多少条记录，类似 grep -B
+	After        int           // 输出每条匹配的记录后多少条记录，类似 grep -A
+	ContextKey   string        // 只在该字段的值与匹配记录相同的记录中查询前后的记录，如 host
+	fields       []logdb.RepoSchemaEntry
+	export       *exportFile
+	csvHeader    bool               // csv 格式已输出表头
+	highlight    *query.Highlighter // 输出到终端时高亮的词
+	dateReason   string             // 选择时间字段的原因，用于 --explain
+}
+
+// writer 日志输出的位置
+func (arg *CtlArg) writer() io.Writer {
+	if arg.export != nil {
+		return arg.export
+	}
+	return os.Stdout
 }
 
 type Config struct {
-	Ak    string   `json:"ak"`
-	Sk    string   `json:"sk"`
-	Repo  []string `json:"repo"`
-	Debug bool     `json:"debug"`
-	Gzip  bool
+	Ak               string            `json:"ak"`
+	Sk               string            `json:"sk"`
+	Repo             []string          `json:"repo"`
+	Debug            bool              `json:"debug"`
+	Timezone         string            `json:"timezone"`         // 解析和显示时间所用的时区，如 Asia/Shanghai ，为空时使用本地时区
+	ConfirmThreshold int               `json:"confirmThreshold"` // scroll 拉取时，总条数超过此值需确认，0 表示不确认
+	DateFields       map[string]string `json:"dateFields"`       // 各 repo 的时间字段，repo 有多个 date 类型字段时按此选择
+	Gzip             bool
 }
 
 func ListRepos(conf *Config, verbose bool) (err error) {
@@ -73,10 +119,10 @@ func showRepos(repos *logdb.ListReposOutput, verbose bool) (err error) {
 	for i, v := range repos.Repos {
 		if verbose {
 			fmt.Printf("%3d:  %-"+sLen+"s\t%s\t%s\t%s\t%s\n",
-				i, v.RepoName, v.Region, v.Retention, v.CreateTime, v.UpdateTime)
+				i, v.RepoName, v.Region, formatRetention(v.Retention), v.CreateTime, v.UpdateTime)
 		} else {
 			fmt.Printf("%3d:  %-"+sLen+"s\t%s\t%s\n",
-				i, v.RepoName, v.Region, v.Retention)
+				i, v.RepoName, v.Region, formatRetention(v.Retention))
 		}
 	}
 	return
@@ -88,7 +134,7 @@ func QuerySample(conf *Config) (err error) {
 		return
 	}
 	qstr := "*"
-	logs, err := doQuery(logdbClient, conf, &qstr, "", 1, false)
+	logs, err := doQuery(logdbClient, conf, &qstr, "", 0, 1, false)
 	if err != nil {
 		return
 	}
@@ -105,7 +151,7 @@ func QuerySample(conf *Config) (err error) {
 func showSample(conf *Config, logs *logdb.QueryLogOutput, repoInfo *logdb.GetRepoOutput) {
 	if logs != nil && len(logs.Data) > 0 {
 		fields, maxFiledLen := getShowFields("*", repoInfo)
-		fmt.Printf("%s\n", formatDbLog(&logs.Data[0], &fields, "\n", maxFiledLen))
+		fmt.Printf("%s\n", formatDbLog(&logs.Data[0], &fields, "\n", maxFiledLen, nil))
 	}
 }
 
@@ -154,14 +200,14 @@ func getField(fields []logdb.RepoSchemaEntry, key string) *logdb.RepoSchemaEntry
 }
 
 func formatDbLog(entity *map[string]interface{}, fields *[]logdb.RepoSchemaEntry,
-	split string, maxFiledLen int) string {
+	split string, maxFiledLen int, hl *query.Highlighter) string {
 	verbose := maxFiledLen > 0
 	formatf := warpRed("%"+strconv.Itoa(maxFiledLen)+"s:") + "\t%.0f"
 	formatv := warpRed("%"+strconv.Itoa(maxFiledLen)+"s:") + "\t%v"
 	values := []string{}
 	for _, entry := range *fields {
 		field := entry.Key
-		v := (*entity)[field]
+		v := FieldValue(entry.ValueType, (*entity)[field])
 		// "valtype":"long"  被转换为 float64 ,显示不友好，单独格式化
 		switch entry.ValueType {
 		case "long":
@@ -170,7 +216,7 @@ func formatDbLog(entity *map[string]interface{}, fields *[]logdb.RepoSchemaEntry
 				values = append(values, replaceNewline(&s))
 			} else {
 				s := fmt.Sprintf("%.0f", v)
-				values = append(values, replaceNewline(&s))
+				values = append(values, highlight(hl, field, replaceNewline(&s)))
 			}
 			break
 		default:
@@ -179,7 +225,7 @@ func formatDbLog(entity *map[string]interface{}, fields *[]logdb.RepoSchemaEntry
 				values = append(values, replaceNewline(&s))
 			} else {
 				s := fmt.Sprint(v)
-				values = append(values, replaceNewline(&s))
+				values = append(values, highlight(hl, field, replaceNewline(&s)))
 			}
 		}
 	}
@@ -195,70 +241,89 @@ func replaceNewline(ps *string) string {
 }
 
 func warpRed(s string) string {
+	if !colorOutput {
+		return s
+	}
 	return fmt.Sprintf("\033[0;31m%s\033[0m", s)
 }
 
 func Query(conf *Config, query string, arg *CtlArg) (err error) {
-	logdbClient, err := buildClient(conf)
-	if err != nil {
+	if err = checkFormat(arg.Format); err != nil {
 		return
 	}
-	repoInfo, err := getRepoInfo(logdbClient, conf)
+	logdbClient, err := buildClient(conf)
 	if err != nil {
 		return
 	}
-	// warn := checkInRetention(arg.Start, arg.End, strings.ToLower(repoInfo.Retention))
-	// log.Warn(warn)
-	sort, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
+	repoInfo, err := getRepoInfo(logdbClient, conf)
 	if err != nil {
 		return
 	}
-	err = execQuery(logdbClient, conf, repoInfo, &query, arg, sort)
-	return
+	return runQuery(logdbClient, conf, repoInfo, query, arg)
 }
 
-func getRepoInfo(logdbClient *logdb.LogdbAPI, conf *Config) (repoInfo *logdb.GetRepoOutput, err error) {
-	repoInfo, err = (*logdbClient).GetRepo(&logdb.GetRepoInput{RepoName: conf.Repo[0]})
+func runQuery(logdbClient *logdb.LogdbAPI, conf *Config, repoInfo *logdb.GetRepoOutput,
+	query string, arg *CtlArg) (err error) {
+	// --explain 只显示是否超出保存时间，不报错也不调整时间范围
+	if !arg.Explain {
+		if err = checkInRetention(repoInfo, arg); err != nil {
+			return
+		}
+	}
+	where := query
+	arg.setHighlight(where)
+	dateField, sort, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
 	if err != nil {
-		repoInfo, err = (*logdbClient).GetRepo(&logdb.GetRepoInput{RepoName: conf.Repo[0]})
+		return
 	}
-	return
-}
-
-// retention :eg: 7d, 30d
-func checkInRetention(start, end *time.Time, retention string) (warn error) {
-	//forever storage
-	if strings.TrimSpace(retention) == "-1" {
+	if arg.Explain {
+		showExplain(conf, repoInfo, query, dateField, sort, arg)
 		return
 	}
-	day := 0
-	for _, c := range retention {
-		if unicode.IsDigit(c) {
-			// ascii , 48 ==> 0
-			day = day*10 + int(c-'0')
-		} else {
-			break
+	if arg.Count {
+		err = showCount(logdbClient, conf, query, dateField, arg)
+		return
+	}
+	if arg.Before > 0 || arg.After > 0 {
+		err = queryWithContext(logdbClient, conf, repoInfo, query, dateField, sort, arg)
+		return
+	}
+	if len(arg.Output) != 0 {
+		arg.export, err = createExportFile(arg.Output)
+		if err != nil {
+			return
 		}
+		defer func() {
+			if err1 := arg.export.Close(); err == nil {
+				err = err1
+			}
+		}()
 	}
-
-	earliest := time.Now().Add(-time.Hour * 24 * time.Duration(day))
-	if earliest.After(*end) {
-		warn = fmt.Errorf("[%v ~ %v]时间范围太过久远，建议在 \"%s\" 之内。", start, end, retention)
+	if arg.Follow {
+		err = follow(logdbClient, conf, repoInfo, where, dateField, arg)
 		return
 	}
+	err = execQuery(logdbClient, conf, repoInfo, &query, arg, dateField, sort)
+	return
+}
 
-	if earliest.After(*start) {
-		warn = fmt.Errorf("[%v ~ %v]时间可能超出范围，不一定能获取到有效数据。最好在 \"%s\" 之内。", start, end, retention)
+func getRepoInfo(logdbClient *logdb.LogdbAPI, conf *Config) (repoInfo *logdb.GetRepoOutput, err error) {
+	repoInfo, err = (*logdbClient).GetRepo(&logdb.GetRepoInput{RepoName: conf.Repo[0]})
+	if err != nil {
+		repoInfo, err = (*logdbClient).GetRepo(&logdb.GetRepoInput{RepoName: conf.Repo[0]})
 	}
 	return
 }
 
 func buildQueryStr(logdbClient *logdb.LogdbAPI, conf *Config,
-	repoInfo *logdb.GetRepoOutput, pquery *string, arg *CtlArg) (sort string, err error) {
-	dateField, sort, err := getDateFieldAndSort(logdbClient, conf, repoInfo, arg)
+	repoInfo *logdb.GetRepoOutput, pquery *string, arg *CtlArg) (dateField string, sort string, err error) {
+	dateField, sort, err = getDateFieldAndSort(logdbClient, conf, repoInfo, arg)
 	if err != nil {
 		return
 	}
+	if err = checkQuery(logdbClient, conf, repoInfo, *pquery, arg); err != nil {
+		return
+	}
 	if len(dateField) != 0 {
 		query := *pquery
 		if len(query) != 0 {
@@ -271,23 +336,26 @@ func buildQueryStr(logdbClient *logdb.LogdbAPI, conf *Config,
 	return
 }
 
-func getDateFieldAndSort(logdbClient *logdb.LogdbAPI, conf *Config,
-	repoInfo *logdb.GetRepoOutput, arg *CtlArg) (dateField string, sort string, err error) {
-	if len(arg.Sort) > 0 {
-		sort = arg.Sort
-	}
-	if len(sort) == 0 && len(arg.OrderField) > 0 {
-		sort = arg.OrderField + ":" + arg.OrderType
+// checkQuery 发送请求前检查查询语句的语法，以及字段是否存在、值是否符合字段类型
+func checkQuery(logdbClient *logdb.LogdbAPI, conf *Config,
+	repoInfo *logdb.GetRepoOutput, q string, arg *CtlArg) (err error) {
+	if arg.NoCheck || len(strings.TrimSpace(q)) == 0 {
+		return
 	}
-
-	if len(arg.DateField) > 0 {
-		dateField = arg.DateField
-		if len(sort) == 0 {
-			sort = dateField + ":" + arg.OrderType
+	if repoInfo == nil {
+		repoInfo, err = getRepoInfo(logdbClient, conf)
+		if err != nil {
+			return
 		}
-		return
 	}
+	if _, err = query.Check(q, repoInfo.Schema); err != nil {
+		err = errors.New(query.Describe(q, err) + "\n如确认查询语句无误，可使用 --noCheck 跳过检查")
+	}
+	return
+}
 
+func getDateFieldAndSort(logdbClient *logdb.LogdbAPI, conf *Config,
+	repoInfo *logdb.GetRepoOutput, arg *CtlArg) (dateField string, sort string, err error) {
 	if repoInfo == nil {
 		repoInfo, err = getRepoInfo(logdbClient, conf)
 		if err != nil {
@@ -295,85 +363,310 @@ func getDateFieldAndSort(logdbClient *logdb.LogdbAPI, conf *Config,
 		}
 	}
 
-	for _, e := range repoInfo.Schema {
-		if e.ValueType == "date" {
-			dateField = e.Key
-			break
+	if len(arg.DateField) > 0 {
+		if !arg.NoCheck {
+			if err = checkDateField(repoInfo.Schema, arg.DateField); err != nil {
+				return
+			}
 		}
+		dateField = arg.DateField
+		arg.dateReason = "--dateField 指定"
+	} else {
+		dateField, arg.dateReason = chooseDateField(repoInfo.Schema, conf.DateFields[conf.Repo[0]])
 	}
+	log.Debugf("dateField: %s (%s)\n", dateField, arg.dateReason)
 
-	if len(sort) == 0 && len(dateField) != 0 {
-		sort = dateField + ":" + arg.OrderType
+	spec, err := buildSort(arg, dateField)
+	if err != nil {
+		return
 	}
+	if !arg.NoCheck && (len(arg.Sort) > 0 || len(arg.OrderField) > 0) {
+		if err = spec.Validate(repoInfo.Schema); err != nil {
+			return
+		}
+	}
+	sort = spec.String()
 	return
 }
 
 func execQuery(logdbClient *logdb.LogdbAPI, conf *Config, repoInfo *logdb.GetRepoOutput,
-	query *string, arg *CtlArg, sort string) (err error) {
-	logs, err := doQuery(logdbClient, conf, query, sort, arg.PreSize, arg.Scroll)
+	query *string, arg *CtlArg, dateField string, sort string) (err error) {
+	var ctrl *sizeController
+	var asc bool
+	if arg.Scroll && arg.AutoSize {
+		var ok bool
+		asc, ok = sortOnlyBy(sort, dateField)
+		if ok {
+			ctrl = newSizeController(arg.Target)
+		} else {
+			log.Warnf("--preSize auto 要求只按时间字段排序，当前排序为 \"%s\"，使用固定的每页 %d 条\n", sort, arg.PreSize)
+		}
+	}
+
+	preSize := arg.PreSize
+	if ctrl != nil {
+		preSize = ctrl.size
+	}
+	if arg.Limit > 0 {
+		preSize = MinInt(preSize, arg.Limit)
+	}
+	firstQuery := func() (*logdb.QueryLogOutput, error) {
+		return doQuery(logdbClient, conf, query, sort, arg.From, preSize, arg.Scroll && ctrl == nil)
+	}
+	var first scrollPage
+	var logs *logdb.QueryLogOutput
+	if arg.RetryPartial {
+		logs, first.retried, err = requery(firstQuery)
+	} else {
+		logs, err = firstQuery()
+	}
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	first.logs = logs
+	first.partial = logs.PartialSuccess
 
 	log.Debugf("FirstQuery: [scroll: %v...(%v), total:%v, state:%v, size: %v]\n", logs.ScrollId[:MinInt(23, len(logs.ScrollId))], len(logs.ScrollId), logs.Total, logs.PartialSuccess, len(logs.Data))
 
-	size := len(logs.Data)
-	total := size
-	showLogs(conf, repoInfo, logs, arg, 1)
-	logs.Data = nil
+	expected := logs.Total - arg.From
+	if arg.Limit > 0 {
+		expected = MinInt(expected, arg.Limit)
+	}
+	if arg.Scroll && !arg.Yes && conf.ConfirmThreshold > 0 && expected > conf.ConfirmThreshold {
+		err = confirm(fmt.Sprintf("满足条件的日志共 %d 条，将拉取 %d 条，超过 %d 条。", logs.Total, expected, conf.ConfirmThreshold))
+		if err != nil {
+			return
+		}
+	}
 
-	for logs.Total > total && len(logs.ScrollId) > 1 && size > 0 {
-		scrollInput := &logdb.QueryScrollInput{
-			RepoName: conf.Repo[0],
-			ScrollId: logs.ScrollId,
-			Scroll:   "8m",
+	summary := newExportSummary()
+	if arg.Scroll {
+		defer summary.print()
+	}
+	var prog *progress
+	if arg.Scroll && arg.Progress {
+		prog = newProgress(expected)
+		defer prog.finish()
+	}
+
+	var tally *exportTally
+	if arg.Verify || arg.Backfill {
+		if len(dateField) == 0 {
+			return errors.New("ERROR: verify 需要时间字段，repo 没有 date 类型字段，请使用 --dateField 指定")
 		}
-		logs, err = (*logdbClient).QueryScroll(scrollInput)
-		if err != nil {
-			// 重试 scroll 查询
-			// scroll 在服务端有有效期，过期后 ScrollId 不再有意义，不能过太久后再重试。
-			// ScrollId 序列化到磁盘没有意义。
-			sleep := []time.Duration{5, 15, 35, 65, 65, 65}
-			for _, s := range sleep {
-				time.Sleep(s * time.Second)
-				logs, err = (*logdbClient).QueryScroll(scrollInput)
-				if err == nil {
-					break
-				}
+		if arg.Limit > 0 || arg.From > 0 {
+			return errors.New("ERROR: verify 不能与 limit/from/page 同时使用")
+		}
+		if err = checkVerifyStep(*arg.Start, *arg.End, arg.VerifyStep); err != nil {
+			return
+		}
+		tally = newExportTally(dateField, *arg.Start, *arg.End, arg.VerifyStep, arg.Backfill)
+	}
+
+	// 后续 scroll 页在后台预取，与输出并行
+	done := make(chan struct{})
+	defer close(done)
+	total := 0
+	var pages <-chan scrollPage
+	if ctrl != nil {
+		pages = fetchAuto(logdbClient, conf, first, newDateCursor(*query, dateField, asc), ctrl, sort, arg, done)
+	} else {
+		var retry *sliceRetry
+		if arg.Scroll && arg.RetryPartial {
+			if dasc, ok := sortOnlyBy(sort, dateField); ok {
+				retry = newSliceRetry(*query, dateField, sort, dasc)
+			} else {
+				log.Warnf("--retry-partial 要求按时间字段排序才能重试 scroll 页，当前排序为 \"%s\"，只重试首次查询\n", sort)
 			}
+		}
+		pages = fetchScroll(logdbClient, conf, first, arg, retry, done)
+	}
+	for page := range pages {
+		if page.err != nil {
+			log.Error(page.err)
+			return page.err
+		}
+		page.logs.Data = limitData(page.logs.Data, arg.Limit, total)
+		size := len(page.logs.Data)
+		summary.add(page, size)
+		if page.partial && arg.Strict {
+			err = fmt.Errorf("ERROR: 第 %d 页部分分片查询失败 (PartialSuccess)，结果不完整", summary.pages)
+			return
+		}
+		if tally != nil {
+			tally.add(page.logs.Data)
+		}
+		arg.export.track(page.logs.Data, dateField)
+		prog.add(size, showLogs(conf, repoInfo, page.logs, arg, arg.From+total+1))
+		total += size
+		page.logs.Data = nil
+		if arg.Limit > 0 && total >= arg.Limit {
+			break
+		}
+	}
+	prog.finish()
+
+	if tally != nil {
+		var diffs []bucketDiff
+		diffs, err = verifyExport(logdbClient, conf, *query, tally)
+		if err != nil {
+			return
+		}
+		if !arg.Backfill {
+			diffs = nil
+		}
+		basc, _ := sortOnlyBy(sort, dateField)
+		for _, d := range diffs {
+			var data []map[string]interface{}
+			data, err = backfillBucket(logdbClient, conf, *query, sort, tally, d.index, basc)
 			if err != nil {
-				log.Error(err)
 				return
 			}
+			fmt.Fprintf(os.Stderr, "backfill bucket=%d records=%d\n", d.index, len(data))
+			showLogs(conf, repoInfo, &logdb.QueryLogOutput{Data: data}, arg, total+1)
+			arg.export.track(data, dateField)
+			summary.records += len(data)
+			summary.backfilled += len(data)
+			total += len(data)
 		}
-		log.Debugf("scroll: %v, logstotal:%v, state:%v, size: %v, total: %v\n", logs.ScrollId, logs.Total, logs.PartialSuccess, size, total)
-		showLogs(conf, repoInfo, logs, arg, total)
-		size = len(logs.Data)
-		total += size
-		logs.Data = nil
-		err = nil
+	}
+	if arg.export != nil {
+		err = writeManifest(conf, repoInfo, *query, dateField, sort, arg, summary)
 	}
 	return
 }
 
-func showLogs(conf *Config, repoInfo *logdb.GetRepoOutput, logs *logdb.QueryLogOutput, arg *CtlArg, from int) {
+// sortOnlyBy 排序是否只按 field 一个字段，及是否升序
+func sortOnlyBy(sort string, field string) (asc bool, ok bool) {
+	if len(field) == 0 || len(sort) == 0 {
+		return
+	}
+	spec, err := ParseSort(sort, "")
+	if err != nil {
+		return
+	}
+	return spec.OnlyBy(field)
+}
+
+// limitData 已输出 done 条时，截取本次最多还能输出的数据
+func limitData(data []map[string]interface{}, limit int, done int) []map[string]interface{} {
+	if limit <= 0 {
+		return data
+	}
+	left := limit - done
+	if left < 0 {
+		left = 0
+	}
+	if len(data) > left {
+		return data[:left]
+	}
+	return data
+}
+
+// checkFormat 检查输出格式，空字符串表示 text
+func checkFormat(format string) error {
+	switch format {
+	case "", "text", "json", "csv":
+		return nil
+	}
+	return fmt.Errorf("ERROR: 不支持的输出格式 %s ，可选 text json csv", format)
+}
+
+// showLogs 输出日志，返回输出的字节数
+func showLogs(conf *Config, repoInfo *logdb.GetRepoOutput, logs *logdb.QueryLogOutput, arg *CtlArg, from int) (n int) {
 	if arg.fields == nil || len(arg.fields) == 0 {
 		arg.fields, _ = getShowFields(arg.Fields, repoInfo)
 	}
 
+	w := arg.writer()
+	switch arg.Format {
+	case "json":
+		for _, v := range logs.Data {
+			c, _ := fmt.Fprintln(w, formatJSONLog(v, arg.fields))
+			n += c
+		}
+		return
+	case "csv":
+		if !arg.csvHeader && len(logs.Data) > 0 {
+			keys := make([]string, len(arg.fields))
+			for i, f := range arg.fields {
+				keys[i] = f.Key
+			}
+			c, _ := fmt.Fprint(w, formatCSVLine(keys))
+			n += c
+			arg.csvHeader = true
+			if arg.export != nil {
+				arg.export.header++
+			}
+		}
+		for _, v := range logs.Data {
+			c, _ := fmt.Fprint(w, formatCSVLine(fieldValues(v, arg.fields)))
+			n += c
+		}
+		return
+	}
 	if arg.ShowIndex {
 		for i, v := range logs.Data {
-			fmt.Printf("%d\t%s\n", i+from, formatDbLog(&v, &arg.fields, arg.Split, -1))
+			c, _ := fmt.Fprintf(w, "%d\t%s\n", i+from, formatDbLog(&v, &arg.fields, arg.Split, -1, arg.highlight))
+			n += c
 		}
 	} else {
 		for _, v := range logs.Data {
-			fmt.Println(formatDbLog(&v, &arg.fields, arg.Split, -1))
+			c, _ := fmt.Fprintln(w, formatDbLog(&v, &arg.fields, arg.Split, -1, arg.highlight))
+			n += c
+		}
+	}
+	return
+}
+
+// formatJSONLog 只保留要显示的字段，每条记录输出为一行 json
+func formatJSONLog(v map[string]interface{}, fields []logdb.RepoSchemaEntry) string {
+	m := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if value, ok := v[f.Key]; ok {
+			m[f.Key] = FieldValue(f.ValueType, value)
 		}
 	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
+
+// fieldValues 各字段格式化后的值，缺少的字段为空字符串
+func fieldValues(v map[string]interface{}, fields []logdb.RepoSchemaEntry) []string {
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		value, ok := v[f.Key]
+		if !ok || value == nil {
+			continue
+		}
+		value = FieldValue(f.ValueType, value)
+		var s string
+		if f.ValueType == "long" {
+			s = fmt.Sprintf("%.0f", value)
+		} else {
+			s = fmt.Sprint(value)
+		}
+		values[i] = replaceNewline(&s)
+	}
+	return values
+}
+
+func formatCSVLine(values []string) string {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	cw.Write(values)
+	cw.Flush()
+	return b.String()
 }
 
 func QueryReqid(conf *Config, reqid string, reqidField string, arg *CtlArg) (err error) {
+	if err = checkFormat(arg.Format); err != nil {
+		return
+	}
 	unixNano, err := parseReqid(reqid)
 	if err != nil {
 		err = fmt.Errorf("reqid：%v 格式不正确：%v", reqid, err)
@@ -397,28 +690,44 @@ func QueryReqid(conf *Config, reqid string, reqidField string, arg *CtlArg) (err
 		return
 	}
 	query := reqidField + ":" + reqid
+	arg.setHighlight(query)
 	t := time.Unix(unixNano/1e9, 0)
 	st := t.Add(-time.Minute * 3)
 	et := t.Add(time.Minute * 10)
 	arg.Start = &st
 	arg.End = &et
-	sort, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
+	if repoInfo == nil {
+		repoInfo, err = getRepoInfo(logdbClient, conf)
+		if err != nil {
+			return
+		}
+	}
+	if err = checkInRetention(repoInfo, arg); err != nil {
+		return
+	}
+	dateField, sort, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
 	if err != nil {
 		return
 	}
-	logs, err := doQuery(logdbClient, conf, &query, sort, 10000, arg.Scroll)
+	withContext := arg.Before > 0 || arg.After > 0
+	if withContext {
+		if err = checkContextArg(arg); err != nil {
+			return
+		}
+	}
+	logs, err := doQuery(logdbClient, conf, &query, sort, 0, 10000, arg.Scroll)
 	if err != nil {
 		return
 	}
 
 	log.Debugf("FirstQuery: [scroll: %v...(%v), total:%v, state:%v, size: %v]\n", logs.ScrollId[:MinInt(23, len(logs.ScrollId))], len(logs.ScrollId), logs.Total, logs.PartialSuccess, len(logs.Data))
+	if logs.PartialSuccess {
+		log.Warn("部分分片查询失败 (PartialSuccess)，结果可能不完整")
+	}
 
 	if len(logs.Data) > 0 {
-		if repoInfo == nil {
-			repoInfo, err = getRepoInfo(logdbClient, conf)
-			if err != nil {
-				return
-			}
+		if withContext {
+			return showContext(logdbClient, conf, repoInfo, logs.Data, dateField, arg)
 		}
 		showLogs(conf, repoInfo, logs, arg, 1)
 	}
@@ -450,7 +759,7 @@ func getReqidField(repoInfo *logdb.GetRepoOutput, fields ...string) string {
 }
 
 func doQuery(logdbClient *logdb.LogdbAPI, conf *Config, qstr *string, sort string,
-	size int, srcoll bool) (logs *logdb.QueryLogOutput, err error) {
+	from int, size int, srcoll bool) (logs *logdb.QueryLogOutput, err error) {
 	if len(conf.Repo) == 0 {
 		err = errors.New("ERROR: HAVE NOT set repo ")
 		return
@@ -459,7 +768,7 @@ func doQuery(logdbClient *logdb.LogdbAPI, conf *Config, qstr *string, sort strin
 		RepoName: conf.Repo[0],
 		Query:    *qstr, //query字段sdk会自动做url编码，用户不需要关心
 		Sort:     sort,
-		From:     0,
+		From:     from,
 		Size:     size,
 	}
 	if srcoll {
@@ -489,3 +798,10 @@ func MinInt(x, y int) int {
 	}
 	return y
 }
+
+func MaxInt(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+const (
+	autoMinSize  = 100
+	autoMaxSize  = 10000
+	autoInitSize = 1000
+	autoMaxBytes = 32 << 20 // 单页响应超过此大小时缩小每页条数
+	autoRetries  = 6
+)
+
+// sizeController 根据每次查询的耗时和响应大小，调整每页拉取的条数，使每次查询耗时接近 target
+type sizeController struct {
+	size   int
+	target time.Duration
+	floor  int // 游标无法前进时每页条数的下限，防止 observe 又将条数减小，反复拉取同一页
+}
+
+func newSizeController(target time.Duration) *sizeController {
+	if target <= 0 {
+		target = 3 * time.Second
+	}
+	return &sizeController{size: autoInitSize, target: target}
+}
+
+// observe 记录一次完整返回的查询：n 条记录，耗时 latency ，约 bytes 字节
+func (c *sizeController) observe(n int, latency time.Duration, bytes int) {
+	if n < c.size {
+		// 不满一页，耗时不代表满页的情况
+		return
+	}
+	ratio := 2.0
+	if latency > 0 {
+		ratio = float64(c.target) / float64(latency)
+	}
+	// 每次最多调整一倍，避免抖动
+	if ratio > 2 {
+		ratio = 2
+	}
+	if ratio < 0.5 {
+		ratio = 0.5
+	}
+	size := int(float64(c.size) * ratio)
+	if bytes > autoMaxBytes {
+		size = MinInt(size, c.size*autoMaxBytes/bytes)
+	}
+	c.set(size)
+}
+
+// backoff 查询超时或响应过大时，每页条数减半
+func (c *sizeController) backoff() {
+	c.set(c.size / 2)
+}
+
+func (c *sizeController) set(size int) {
+	if size < c.floor {
+		size = c.floor
+	}
+	if size < autoMinSize {
+		size = autoMinSize
+	}
+	if size > autoMaxSize {
+		size = autoMaxSize
+	}
+	if size != c.size {
+		log.Debugf("preSize auto: %d -> %d\n", c.size, size)
+	}
+	c.size = size
+}
+
+// hold 游标无法前进时增大每页条数，并在游标前进之前不再减小
+func (c *sizeController) hold(size int) {
+	c.floor = MinInt(size, autoMaxSize)
+	c.set(size)
+}
+
+// release 游标前进后取消下限
+func (c *sizeController) release() {
+	c.floor = 0
+}
+
+// fetchAuto 与 fetchScroll 相同，但每页通过 dateCursor 单独查询，每页条数由 sizeController 调整。
+// scroll 的每页条数在创建时已确定，无法中途调整，因此不使用 scroll 。
+// 要求按时间字段排序，first 为按 ctrl.size 条数的首次查询结果。
+func fetchAuto(logdbClient *logdb.LogdbAPI, conf *Config, first scrollPage,
+	cursor *dateCursor, ctrl *sizeController, sort string, arg *CtlArg, done <-chan struct{}) <-chan scrollPage {
+	prefetch := arg.Prefetch
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	pages := make(chan scrollPage, prefetch)
+	go func() {
+		defer close(pages)
+		page := first
+		size := ctrl.size
+		fetched := 0
+		for {
+			logs := page.logs
+			full := len(logs.Data) >= size
+			data, ok := cursor.advance(logs.Data)
+			if !ok {
+				sendErr(pages, done, errors.New("ERROR: 记录缺少可解析的时间字段 "+cursor.dateField+" ，不能使用 --preSize auto"))
+				return
+			}
+			if full && len(data) == 0 {
+				// 同一秒内的记录超过一页，游标无法前进
+				if size >= autoMaxSize {
+					sendErr(pages, done, errors.New("ERROR: 同一秒内的记录超过 10000 条，不能使用 --preSize auto ，请指定 --preSize"))
+					return
+				}
+				ctrl.hold(size * 2)
+			} else if len(data) > 0 {
+				ctrl.release()
+			}
+			fetched += len(data)
+			more := full && (arg.Limit <= 0 || fetched < arg.Limit)
+			logs.Data = data
+			select {
+			case pages <- page:
+			case <-done:
+				return
+			}
+			if !more {
+				return
+			}
+
+			var err error
+			page, size, err = queryAutoPage(logdbClient, conf, cursor, ctrl, sort, arg.RetryPartial)
+			if err != nil {
+				sendErr(pages, done, err)
+				return
+			}
+		}
+	}()
+	return pages
+}
+
+// queryAutoPage 拉取下一页，超时或出错时缩小每页条数重试；
+// retryPartial 时，部分分片失败的页重新查询
+func queryAutoPage(logdbClient *logdb.LogdbAPI, conf *Config, cursor *dateCursor,
+	ctrl *sizeController, sort string, retryPartial bool) (page scrollPage, size int, err error) {
+	query := cursor.pageQuery()
+	for i := 0; i <= autoRetries; i++ {
+		size = ctrl.size
+		start := time.Now()
+		var logs *logdb.QueryLogOutput
+		if retryPartial {
+			logs, page.retried, err = requery(func() (*logdb.QueryLogOutput, error) {
+				return doQuery(logdbClient, conf, &query, sort, 0, size, false)
+			})
+		} else {
+			logs, err = doQuery(logdbClient, conf, &query, sort, 0, size, false)
+		}
+		latency := time.Since(start)
+		if err == nil {
+			page.logs = logs
+			page.partial = logs.PartialSuccess
+			bytes := 0
+			if data, e := json.Marshal(logs.Data); e == nil {
+				bytes = len(data)
+			}
+			log.Debugf("preSize auto: size: %d, got: %d, latency: %v, bytes: %d\n", size, len(logs.Data), latency, bytes)
+			ctrl.observe(len(logs.Data), latency, bytes)
+			return
+		}
+		log.Debugf("preSize auto: size: %d, latency: %v, err: %v\n", size, latency, err)
+		if isTimeout(err) || isTooLarge(err) {
+			ctrl.backoff()
+		} else {
+			time.Sleep(time.Duration(i+1) * 5 * time.Second)
+		}
+	}
+	return
+}
+
+func sendErr(pages chan<- scrollPage, done <-chan struct{}, err error) {
+	select {
+	case pages <- scrollPage{err: err}:
+	case <-done:
+	}
+}
+
+func isTimeout(err error) bool {
+	if e, ok := err.(interface {
+		Timeout() bool
+	}); ok && e.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded")
+}
+
+func isTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "too large") || strings.Contains(msg, "413")
+}
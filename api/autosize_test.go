@@ -0,0 +1,83 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSizeController(t *testing.T) {
+	target := 2 * time.Second
+	type step struct {
+		op      string // observe backoff hold release
+		n       int    // observe 的条数，hold 的条数
+		latency time.Duration
+		bytes   int
+		want    int
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{"adjust to target", []step{
+			{"observe", 1000, time.Second, 0, 2000},
+			{"observe", 2000, 500 * time.Millisecond, 0, 4000},
+			{"observe", 4000, 4 * time.Second, 0, 2000},
+			{"observe", 2000, 10 * time.Second, 0, 1000},
+			// 不满一页时不调整
+			{"observe", 10, 10 * time.Second, 0, 1000},
+		}},
+		{"limits", []step{
+			{"observe", 1000, 0, 0, 2000},
+			{"observe", 2000, 0, 0, 4000},
+			{"observe", 4000, 0, 0, 8000},
+			{"observe", 8000, 0, 0, 10000},
+			{"observe", 10000, 0, 0, 10000},
+			{"backoff", 0, 0, 0, 5000},
+			{"backoff", 0, 0, 0, 2500},
+			{"backoff", 0, 0, 0, 1250},
+			{"backoff", 0, 0, 0, 625},
+			{"backoff", 0, 0, 0, 312},
+			{"backoff", 0, 0, 0, 156},
+			{"backoff", 0, 0, 0, 100},
+			{"backoff", 0, 0, 0, 100},
+		}},
+		{"response too large", []step{
+			{"observe", 1000, time.Second, autoMaxBytes * 4, 250},
+		}},
+		// 游标无法前进时保持下限，observe 和 backoff 都不能减小，游标前进后取消
+		{"floor while stuck", []step{
+			{"hold", 2000, 0, 0, 2000},
+			{"observe", 2000, 10 * time.Second, 0, 2000},
+			{"backoff", 0, 0, 0, 2000},
+			{"hold", 4000, 0, 0, 4000},
+			{"observe", 4000, 10 * time.Second, 0, 4000},
+			{"release", 0, 0, 0, 4000},
+			{"observe", 4000, 10 * time.Second, 0, 2000},
+			{"backoff", 0, 0, 0, 1000},
+		}},
+		{"floor capped", []step{
+			{"hold", 20000, 0, 0, 10000},
+			{"backoff", 0, 0, 0, 10000},
+			{"release", 0, 0, 0, 10000},
+			{"backoff", 0, 0, 0, 5000},
+		}},
+	}
+	for _, c := range cases {
+		ctrl := newSizeController(target)
+		for i, s := range c.steps {
+			switch s.op {
+			case "observe":
+				ctrl.observe(s.n, s.latency, s.bytes)
+			case "backoff":
+				ctrl.backoff()
+			case "hold":
+				ctrl.hold(s.n)
+			case "release":
+				ctrl.release()
+			}
+			if ctrl.size != s.want {
+				t.Errorf("%s: step %d %s: size = %d, want %d", c.name, i, s.op, ctrl.size, s.want)
+			}
+		}
+	}
+}
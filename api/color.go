@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/qiniuts/qlogctl/query"
+)
+
+const (
+	colorHighlight = "\033[01;31m"
+	colorReset     = "\033[0m"
+)
+
+// colorOutput 输出到 stdout 的内容是否使用颜色，默认在 stdout 为终端时使用
+var colorOutput = autoColor()
+
+func autoColor() bool {
+	return isTerminal(os.Stdout) && os.Getenv("TERM") != "dumb" && os.Getenv("NO_COLOR") == ""
+}
+
+// SetColor 设置是否使用颜色：auto 在 stdout 为终端时使用，always 总是使用，never 不使用
+func SetColor(mode string) error {
+	switch mode {
+	case "", "auto":
+		colorOutput = autoColor()
+	case "always":
+		colorOutput = true
+	case "never":
+		colorOutput = false
+	default:
+		return fmt.Errorf("ERROR: color 应为 auto always 或 never : %s", mode)
+	}
+	return nil
+}
+
+// setHighlight 根据查询语句设置要高亮的词，只在输出到 stdout 且使用颜色时生效
+func (arg *CtlArg) setHighlight(q string) {
+	arg.highlight = nil
+	if !colorOutput || len(arg.Output) != 0 {
+		return
+	}
+	if n, err := query.Parse(q); err == nil && n != nil {
+		arg.highlight = query.NewHighlighter(n)
+	}
+}
+
+// highlight 高亮字段值中与查询条件匹配的部分
+func highlight(hl *query.Highlighter, field string, s string) string {
+	spans := hl.Spans(field, s)
+	if len(spans) == 0 {
+		return s
+	}
+	var b bytes.Buffer
+	last := 0
+	for _, sp := range spans {
+		b.WriteString(s[last:sp[0]])
+		b.WriteString(colorHighlight)
+		b.WriteString(s[sp[0]:sp[1]])
+		b.WriteString(colorReset)
+		last = sp[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+	"github.com/qiniuts/qlogctl/query"
+)
+
+const (
+	// contextSlack 查询上下文时多取的条数，用于去掉与匹配记录时间相同的记录
+	contextSlack     = 20
+	contextSeparator = "--"
+)
+
+// contextGroup 一条或多条匹配的记录及其前后的记录，按时间升序排列
+type contextGroup struct {
+	records []map[string]interface{}
+	keys    []uint64
+	matched map[uint64]bool
+}
+
+func (g *contextGroup) has(key uint64) bool {
+	for _, k := range g.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *contextGroup) add(v map[string]interface{}, key uint64) {
+	if !g.has(key) {
+		g.records = append(g.records, v)
+		g.keys = append(g.keys, key)
+	}
+}
+
+func checkContextArg(arg *CtlArg) error {
+	switch {
+	case arg.Scroll || arg.Follow:
+		return errors.New("ERROR: -A/-B/-C 不能与 --scroll 、--follow 同时使用")
+	case len(arg.Output) != 0:
+		return errors.New("ERROR: -A/-B/-C 不能与 --output 同时使用")
+	case arg.Format == "csv":
+		return errors.New("ERROR: -A/-B/-C 不支持 csv 格式")
+	case arg.Before < 0 || arg.After < 0:
+		return errors.New("ERROR: -A/-B/-C 不能为负数")
+	}
+	return nil
+}
+
+// queryWithContext 查询满足条件的日志，并输出每条日志前后的记录，类似 grep -C
+func queryWithContext(logdbClient *logdb.LogdbAPI, conf *Config, repoInfo *logdb.GetRepoOutput,
+	q string, dateField string, sort string, arg *CtlArg) (err error) {
+	if err = checkContextArg(arg); err != nil {
+		return
+	}
+	size := arg.PreSize
+	if arg.Limit > 0 {
+		size = MinInt(size, arg.Limit)
+	}
+	logs, err := doQuery(logdbClient, conf, &q, sort, arg.From, size, false)
+	if err != nil {
+		return
+	}
+	if logs.PartialSuccess {
+		log.Warn("部分分片查询失败 (PartialSuccess)，结果可能不完整")
+	}
+	return showContext(logdbClient, conf, repoInfo, logs.Data, dateField, arg)
+}
+
+// showContext 对每条匹配的记录，查询时间字段上紧邻的前 Before 条和后 After 条记录，
+// 指定 ContextKey 时只查询该字段的值与匹配记录相同的记录。上下文重叠的分组合并输出
+func showContext(logdbClient *logdb.LogdbAPI, conf *Config, repoInfo *logdb.GetRepoOutput,
+	data []map[string]interface{}, dateField string, arg *CtlArg) (err error) {
+	if len(dateField) == 0 {
+		return errors.New("ERROR: -A/-B/-C 需要时间字段，repo 没有 date 类型字段，请使用 --dateField 指定")
+	}
+	if len(arg.ContextKey) != 0 && query.LookupField(repoInfo.Schema, arg.ContextKey) == nil {
+		return fmt.Errorf("ERROR: repo %s 中没有字段 %s", conf.Repo[0], arg.ContextKey)
+	}
+	if len(data) > 100 {
+		log.Warnf("共 %d 条匹配的记录，每条需查询 2 次上下文，可能较慢\n", len(data))
+	}
+
+	// overlaps 只与前一组比较，匹配的记录需按时间升序处理
+	matches := append([]map[string]interface{}{}, data...)
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareValues(matches[i][dateField], matches[j][dateField]) < 0
+	})
+	var groups []*contextGroup
+	for _, v := range matches {
+		g, err := fetchContext(logdbClient, conf, v, dateField, arg)
+		if err != nil {
+			return err
+		}
+		if n := len(groups); n > 0 && overlaps(groups[n-1], g) {
+			groups[n-1] = mergeGroups(groups[n-1], g, dateField)
+			continue
+		}
+		groups = append(groups, g)
+	}
+
+	if arg.fields == nil || len(arg.fields) == 0 {
+		arg.fields, _ = getShowFields(arg.Fields, repoInfo)
+	}
+	// json 格式中，前后的记录增加 "_context": true
+	jsonFields := append(append([]logdb.RepoSchemaEntry{}, arg.fields...), logdb.RepoSchemaEntry{Key: "_context"})
+	w := arg.writer()
+	index := arg.From + 1
+	for i, g := range groups {
+		if i > 0 && arg.Format != "json" {
+			fmt.Fprintln(w, contextSeparator)
+		}
+		for j, v := range g.records {
+			matched := g.matched[g.keys[j]]
+			if arg.Format == "json" {
+				if !matched {
+					v = markContext(v)
+				}
+				fmt.Fprintln(w, formatJSONLog(v, jsonFields))
+				continue
+			}
+			line := formatDbLog(&v, &arg.fields, arg.Split, -1, arg.highlight)
+			if !arg.ShowIndex {
+				fmt.Fprintln(w, line)
+				continue
+			}
+			// 与 grep 相同，匹配的记录序号后为 : ，上下文为 -
+			sep := "-"
+			if matched {
+				sep = ":"
+			}
+			fmt.Fprintf(w, "%d%s\t%s\n", index, sep, line)
+			index++
+		}
+	}
+	return
+}
+
+// fetchContext 查询一条记录前后的记录
+func fetchContext(logdbClient *logdb.LogdbAPI, conf *Config, v map[string]interface{},
+	dateField string, arg *CtlArg) (g *contextGroup, err error) {
+	key := fingerprint(v)
+	g = &contextGroup{matched: map[uint64]bool{key: true}}
+	t, ok := recordTime(v[dateField])
+	if !ok {
+		g.add(v, key)
+		return
+	}
+	where := ""
+	if len(arg.ContextKey) != 0 {
+		value, ok := query.Lookup(v, arg.ContextKey)
+		if !ok || value == nil {
+			g.add(v, key)
+			return
+		}
+		where = query.Eq(arg.ContextKey, topValue(value)) + " AND "
+	}
+	bound := t.Format(DateLayoutMilli)
+
+	var before, after []map[string]interface{}
+	if arg.Before > 0 {
+		q := where + dateField + ":[* TO " + bound + "]"
+		if before, err = contextRecords(logdbClient, conf, q, dateField+":desc", key, arg.Before); err != nil {
+			return
+		}
+	}
+	if arg.After > 0 {
+		q := where + dateField + ":[" + bound + " TO *]"
+		if after, err = contextRecords(logdbClient, conf, q, dateField+":asc", key, arg.After); err != nil {
+			return
+		}
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		g.add(before[i], fingerprint(before[i]))
+	}
+	g.add(v, key)
+	for _, r := range after {
+		g.add(r, fingerprint(r))
+	}
+	return
+}
+
+// contextRecords 按 sort 查询 n 条记录，不包括匹配的记录本身
+func contextRecords(logdbClient *logdb.LogdbAPI, conf *Config, q string, sort string,
+	exclude uint64, n int) ([]map[string]interface{}, error) {
+	logs, err := doQuery(logdbClient, conf, &q, sort, 0, MinInt(n+contextSlack, 10000), false)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	for _, r := range logs.Data {
+		if len(records) >= n {
+			break
+		}
+		if fingerprint(r) != exclude {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func overlaps(a, b *contextGroup) bool {
+	for _, k := range b.keys {
+		if a.has(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeGroups(a, b *contextGroup, dateField string) *contextGroup {
+	g := &contextGroup{matched: map[uint64]bool{}}
+	for _, src := range []*contextGroup{a, b} {
+		for i, v := range src.records {
+			g.add(v, src.keys[i])
+		}
+		for k := range src.matched {
+			g.matched[k] = true
+		}
+	}
+	idx := make([]int, len(g.records))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return compareValues(g.records[idx[i]][dateField], g.records[idx[j]][dateField]) < 0
+	})
+	merged := &contextGroup{matched: g.matched}
+	for _, i := range idx {
+		merged.add(g.records[i], g.keys[i])
+	}
+	return merged
+}
+
+// markContext 复制记录并标记为上下文，用于 json 格式
+func markContext(v map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(v)+1)
+	for k, value := range v {
+		m[k] = value
+	}
+	m["_context"] = true
+	return m
+}
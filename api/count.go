@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// 并发查询条数时的默认并发数
+const defaultConcurrency = 8
+
+// countQuery 查询满足条件的总条数。只拉取 1 条数据，使用返回的 Total
+func countQuery(logdbClient *logdb.LogdbAPI, conf *Config, query string) (total int, partial bool, err error) {
+	logs, err := doQuery(logdbClient, conf, &query, "", 0, 1, false)
+	if err != nil {
+		return
+	}
+	return logs.Total, logs.PartialSuccess, nil
+}
+
+// countBuckets 并发查询多个条件的条数，结果与 queries 一一对应
+func countBuckets(logdbClient *logdb.LogdbAPI, conf *Config, queries []string, concurrency int) (counts []int, err error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	counts = make([]int, len(queries))
+	errs := make([]error, len(queries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				total, partial, e := countQuery(logdbClient, conf, queries[i])
+				if e == nil && partial {
+					log.Warnf("部分分片查询失败 (PartialSuccess)，条数可能偏小: %s\n", queries[i])
+				}
+				counts[i], errs[i] = total, e
+			}
+		}()
+	}
+	for i := range queries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, e := range errs {
+		if e != nil {
+			return nil, e
+		}
+	}
+	return
+}
+
+var niceIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// autoInterval 选择一个合适的分桶间隔，使 [start, end] 大约分为不超过 buckets 个桶
+func autoInterval(start, end time.Time, buckets int) time.Duration {
+	span := end.Sub(start)
+	for _, d := range niceIntervals {
+		if span/d <= time.Duration(buckets) {
+			return d
+		}
+	}
+	d := niceIntervals[len(niceIntervals)-1]
+	return (span/time.Duration(buckets)/d + 1) * d
+}
+
+func showCount(logdbClient *logdb.LogdbAPI, conf *Config, query string, dateField string, arg *CtlArg) (err error) {
+	total, partial, err := countQuery(logdbClient, conf, query)
+	if err != nil {
+		return
+	}
+	fmt.Printf("total:     %d\n", total)
+	if len(dateField) != 0 {
+		fmt.Printf("dateField: %s\n", dateField)
+		fmt.Printf("start:     %s\n", arg.Start.Format(DateLayout))
+		fmt.Printf("end:       %s\n", arg.End.Format(DateLayout))
+	} else {
+		fmt.Println("dateField: <none> (repo 没有 date 类型字段，未限定时间范围)")
+	}
+	if partial {
+		fmt.Println("WARN: 部分分片查询失败 (PartialSuccess)，total 可能偏小")
+	}
+	return
+}
+
+func showExplain(conf *Config, repoInfo *logdb.GetRepoOutput, query string, dateField string, sort string, arg *CtlArg) {
+	if len(dateField) == 0 {
+		dateField = "<none>"
+	}
+	if len(arg.dateReason) != 0 {
+		dateField += " (" + arg.dateReason + ")"
+	}
+	if len(sort) == 0 {
+		sort = "<none>"
+	}
+	fmt.Printf("repo:      %s\n", conf.Repo[0])
+	fmt.Printf("query:     %s\n", query)
+	fmt.Printf("dateField: %s\n", dateField)
+	fmt.Printf("sort:      %s\n", sort)
+	fmt.Printf("start:     %s\n", arg.Start.Format(DateLayout))
+	fmt.Printf("end:       %s\n", arg.End.Format(DateLayout))
+	fmt.Printf("retention: %s\n", explainRetention(repoInfo, arg))
+	fmt.Printf("preSize:   %d\n", arg.PreSize)
+	fmt.Printf("scroll:    %v\n", arg.Scroll)
+	fmt.Printf("from:      %d\n", arg.From)
+	fmt.Printf("limit:     %d\n", arg.Limit)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// dateCursor 按时间字段翻页：每页都是一次独立的查询，在原查询条件上再限定时间字段
+// 从上一页最后一条记录的时间（精确到秒）开始，因此每页的条数可以不同。
+// 同一秒内的记录可能在相邻两页重复出现，通过记录指纹去重。
+type dateCursor struct {
+	query     string // 原查询条件，已包含时间范围
+	dateField string
+	asc       bool
+	boundary  time.Time       // 上一页最后一条记录所在的秒
+	seen      map[uint64]bool // boundary 这一秒内已输出的记录
+}
+
+func newDateCursor(query string, dateField string, asc bool) *dateCursor {
+	return &dateCursor{
+		query:     query,
+		dateField: dateField,
+		asc:       asc,
+		seen:      map[uint64]bool{},
+	}
+}
+
+// pageQuery 下一页的查询条件
+func (c *dateCursor) pageQuery() string {
+	if c.boundary.IsZero() {
+		return c.query
+	}
+	// DateLayout 只精确到秒，边界这一秒需要完整包含在内
+	if c.asc {
+		return "(" + c.query + ") AND " + c.dateField + ":[" + c.boundary.Format(DateLayout) + " TO *]"
+	}
+	return "(" + c.query + ") AND " + c.dateField + ":[* TO " + c.boundary.Add(time.Second).Format(DateLayout) + "}"
+}
+
+// advance 过滤掉已输出的记录，并把游标移到本页最后一条记录。
+// 返回未输出过的记录；记录缺少可解析的时间字段时返回 false
+func (c *dateCursor) advance(data []map[string]interface{}) ([]map[string]interface{}, bool) {
+	fresh := make([]map[string]interface{}, 0, len(data))
+	for _, v := range data {
+		t, ok := recordTime(v[c.dateField])
+		if !ok {
+			return nil, false
+		}
+		sec := t.Truncate(time.Second)
+		fp := fingerprint(v)
+		if sec.Equal(c.boundary) && c.seen[fp] {
+			continue
+		}
+		if !sec.Equal(c.boundary) {
+			c.boundary = sec
+			c.seen = map[uint64]bool{}
+		}
+		c.seen[fp] = true
+		fresh = append(fresh, v)
+	}
+	return fresh, true
+}
+
+// sameSecond boundary 这一秒内已输出的记录条数
+func (c *dateCursor) sameSecond() int {
+	return len(c.seen)
+}
+
+// fingerprint 记录的指纹。json 序列化时 map 的 key 有序，相同内容的记录结果相同
+func fingerprint(v map[string]interface{}) uint64 {
+	data, _ := json.Marshal(v)
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+var recordTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05",
+}
+
+// recordTime 解析记录中 date 类型字段的值
+func recordTime(v interface{}) (t time.Time, ok bool) {
+	switch x := v.(type) {
+	case string:
+		x = strings.TrimSpace(x)
+		for _, layout := range recordTimeLayouts {
+			t, err := time.Parse(layout, x)
+			if err == nil {
+				return t, true
+			}
+		}
+	case float64:
+		// 毫秒时间戳
+		return time.Unix(0, int64(x)*int64(time.Millisecond)), true
+	}
+	return
+}
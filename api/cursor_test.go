@@ -0,0 +1,76 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDateCursorAdvance(t *testing.T) {
+	rec := func(id, ts string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "ts": "2024-01-01T10:00:" + ts + "+08:00"}
+	}
+	type page struct {
+		data       []map[string]interface{}
+		fresh      string // 未输出过的记录的 id
+		sameSecond int
+	}
+	cases := []struct {
+		name  string
+		pages []page
+	}{
+		{"different seconds", []page{
+			{[]map[string]interface{}{rec("a", "01"), rec("b", "02")}, "a b", 1},
+			{[]map[string]interface{}{rec("b", "02"), rec("c", "03"), rec("d", "04")}, "c d", 1},
+		}},
+		// 整页都在同一秒内，游标无法前进，sameSecond 为下一页需要跳过的条数
+		{"same second page", []page{
+			{[]map[string]interface{}{rec("a", "01"), rec("b", "01"), rec("c", "01")}, "a b c", 3},
+			{[]map[string]interface{}{rec("a", "01"), rec("b", "01"), rec("c", "01")}, "", 3},
+			{[]map[string]interface{}{rec("d", "01"), rec("e", "01"), rec("f", "01")}, "d e f", 6},
+			{[]map[string]interface{}{rec("f", "01"), rec("g", "01"), rec("h", "02")}, "g h", 1},
+		}},
+		// 同一秒的记录按指纹去重，不同秒的记录不再比较
+		{"dedupe across pages", []page{
+			{[]map[string]interface{}{rec("a", "01"), rec("b", "02"), rec("c", "02")}, "a b c", 2},
+			{[]map[string]interface{}{rec("c", "02"), rec("b", "02"), rec("d", "02")}, "d", 3},
+			{[]map[string]interface{}{rec("d", "02"), rec("e", "03")}, "e", 1},
+			{[]map[string]interface{}{rec("e", "03"), rec("e", "03")}, "", 1},
+		}},
+	}
+	for _, c := range cases {
+		cursor := newDateCursor("status:500", "ts", true)
+		if q := cursor.pageQuery(); q != "status:500" {
+			t.Errorf("%s: first pageQuery = %q", c.name, q)
+		}
+		for i, p := range c.pages {
+			data, ok := cursor.advance(p.data)
+			if !ok {
+				t.Fatalf("%s: page %d: advance failed", c.name, i)
+			}
+			var ids []string
+			for _, v := range data {
+				ids = append(ids, v["id"].(string))
+			}
+			if got := strings.Join(ids, " "); got != p.fresh {
+				t.Errorf("%s: page %d: fresh = %q, want %q", c.name, i, got, p.fresh)
+			}
+			if got := cursor.sameSecond(); got != p.sameSecond {
+				t.Errorf("%s: page %d: sameSecond = %d, want %d", c.name, i, got, p.sameSecond)
+			}
+		}
+	}
+
+	cursor := newDateCursor("status:500", "ts", true)
+	cursor.advance([]map[string]interface{}{rec("a", "05")})
+	if q := cursor.pageQuery(); q != "(status:500) AND ts:[2024-01-01T10:00:05+0800 TO *]" {
+		t.Errorf("asc pageQuery = %q", q)
+	}
+	cursor = newDateCursor("status:500", "ts", false)
+	cursor.advance([]map[string]interface{}{rec("a", "05")})
+	if q := cursor.pageQuery(); q != "(status:500) AND ts:[* TO 2024-01-01T10:00:06+0800}" {
+		t.Errorf("desc pageQuery = %q", q)
+	}
+	if _, ok := cursor.advance([]map[string]interface{}{{"id": "x"}}); ok {
+		t.Error("advance should fail on records without a date field")
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// dateFieldNames 常用作日志时间的字段名，越靠前越优先
+var dateFieldNames = []string{"timestamp", "@timestamp", "time", "log_time", "logtime", "event_time",
+	"eventtime", "datetime", "date", "ts"}
+
+// secondaryDateWords 字段名中含有这些词的，一般是写入、处理等时间，而不是日志本身的时间
+var secondaryDateWords = []string{"ingest", "receive", "recv", "arrive", "collect", "process", "index",
+	"insert", "upload", "create", "update", "modify", "expire"}
+
+// dateFieldScore 按字段名估计字段作为日志时间的可能性
+func dateFieldScore(key string) int {
+	name := strings.ToLower(key)
+	score := 0
+	for i, n := range dateFieldNames {
+		if name == n {
+			score = 100 - i
+			break
+		}
+	}
+	if score == 0 && (strings.Contains(name, "time") || strings.Contains(name, "date")) {
+		score = 50
+	}
+	for _, w := range secondaryDateWords {
+		if strings.Contains(name, w) {
+			score -= 60
+			break
+		}
+	}
+	return score
+}
+
+// chooseDateField 选择时间字段：配置文件中为 repo 指定的字段优先，否则按字段名排序 date 类型的字段，
+// 得分相同时取 schema 中靠前的。返回选择的字段和原因，没有 date 类型字段时 field 为空
+func chooseDateField(schema []logdb.RepoSchemaEntry, preferred string) (field string, reason string) {
+	var candidates []string
+	best := 0
+	ambiguous := false
+	for _, e := range schema {
+		if e.ValueType != "date" {
+			continue
+		}
+		candidates = append(candidates, e.Key)
+		score := dateFieldScore(e.Key)
+		switch {
+		case len(candidates) == 1 || score > best:
+			field, best, ambiguous = e.Key, score, false
+		case score == best:
+			ambiguous = true
+		}
+	}
+	if len(preferred) != 0 {
+		for _, c := range candidates {
+			if c == preferred {
+				return c, "配置文件 dateFields 中指定"
+			}
+		}
+		if e := getField(schema, preferred); e == nil {
+			log.Warnf("配置文件 dateFields 中指定的字段 %s 不存在，忽略\n", preferred)
+		} else {
+			log.Warnf("配置文件 dateFields 中指定的字段 %s 为 %s 类型，不是 date 类型，忽略\n", preferred, e.ValueType)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", "repo 没有 date 类型字段，未限定时间范围"
+	case 1:
+		return field, "唯一的 date 类型字段"
+	}
+	reason = "按字段名选择，候选: " + strings.Join(candidates, ", ")
+	if ambiguous {
+		log.Warnf("有多个 date 类型字段 (%s)，使用 %s 。可用 --dateField 或配置文件 dateFields 指定\n",
+			strings.Join(candidates, ", "), field)
+	}
+	return
+}
+
+// checkDateField 检查 --dateField 指定的字段存在且为 date 类型
+func checkDateField(schema []logdb.RepoSchemaEntry, key string) error {
+	var candidates []string
+	for _, e := range schema {
+		if e.ValueType == "date" {
+			candidates = append(candidates, e.Key)
+		}
+	}
+	hint := "repo 没有 date 类型字段"
+	if len(candidates) > 0 {
+		hint = "可选: " + strings.Join(candidates, ", ")
+	}
+	e := getField(schema, key)
+	if e == nil {
+		return fmt.Errorf("ERROR: --dateField 指定的字段 %s 不存在，%s", key, hint)
+	}
+	if e.ValueType != "date" {
+		return errors.New("ERROR: --dateField 指定的字段 " + key + " 为 " + e.ValueType + " 类型，不是 date 类型，" + hint)
+	}
+	return nil
+}
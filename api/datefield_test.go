@@ -0,0 +1,89 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+func TestDateFieldScore(t *testing.T) {
+	// 每组前一个字段应比后一个得分高
+	cases := [][2]string{
+		{"timestamp", "ingest_time"},
+		{"timestamp", "@timestamp"},
+		{"Timestamp", "time"},
+		{"ts", "ingest_ts"},
+		{"request_time", "receive_time"},
+		{"request_time", "foo"},
+		{"ts", "create_date"},
+	}
+	for _, c := range cases {
+		if a, b := dateFieldScore(c[0]), dateFieldScore(c[1]); a <= b {
+			t.Errorf("dateFieldScore(%q) = %d, should be greater than dateFieldScore(%q) = %d", c[0], a, c[1], b)
+		}
+	}
+	if s := dateFieldScore("request_time"); s != dateFieldScore("start_date") {
+		t.Errorf("dateFieldScore(request_time) = %d, want same as start_date", s)
+	}
+}
+
+func TestChooseDateField(t *testing.T) {
+	schema := func(fields ...string) []logdb.RepoSchemaEntry {
+		var s []logdb.RepoSchemaEntry
+		for _, f := range fields {
+			kv := strings.SplitN(f, ":", 2)
+			s = append(s, logdb.RepoSchemaEntry{Key: kv[0], ValueType: kv[1]})
+		}
+		return s
+	}
+	cases := []struct {
+		schema    []logdb.RepoSchemaEntry
+		preferred string
+		field     string
+		reason    string
+	}{
+		{schema("ingest_time:date", "timestamp:date", "msg:string"), "", "timestamp", "按字段名选择"},
+		{schema("timestamp:date", "ingest_time:date"), "", "timestamp", "按字段名选择"},
+		{schema("ingest_time:date", "msg:string"), "", "ingest_time", "唯一的 date 类型字段"},
+		// 得分相同时取 schema 中靠前的
+		{schema("start_date:date", "request_time:date"), "", "start_date", "按字段名选择"},
+		{schema("request_time:date", "start_date:date"), "", "request_time", "按字段名选择"},
+		{schema("msg:string", "time:string"), "", "", "repo 没有 date 类型字段"},
+		{nil, "", "", "repo 没有 date 类型字段"},
+		// 配置文件指定的字段优先；不存在或不是 date 类型时按字段名选择
+		{schema("timestamp:date", "ingest_time:date"), "ingest_time", "ingest_time", "配置文件 dateFields 中指定"},
+		{schema("timestamp:date", "ingest_time:date"), "missing", "timestamp", "按字段名选择"},
+		{schema("timestamp:date", "time:string"), "time", "timestamp", "唯一的 date 类型字段"},
+		{schema("msg:string"), "msg", "", "repo 没有 date 类型字段"},
+	}
+	for _, c := range cases {
+		field, reason := chooseDateField(c.schema, c.preferred)
+		if field != c.field || !strings.Contains(reason, c.reason) {
+			t.Errorf("chooseDateField(%v, %q) = %q, %q, want %q, %q", c.schema, c.preferred, field, reason, c.field, c.reason)
+		}
+	}
+}
+
+func TestCheckDateField(t *testing.T) {
+	schema := []logdb.RepoSchemaEntry{
+		{Key: "timestamp", ValueType: "date"},
+		{Key: "msg", ValueType: "string"},
+	}
+	cases := []struct {
+		key, msg string
+	}{
+		{"timestamp", ""},
+		{"missing", "不存在"},
+		{"msg", "为 string 类型"},
+	}
+	for _, c := range cases {
+		err := checkDateField(schema, c.key)
+		switch {
+		case c.msg == "" && err != nil:
+			t.Errorf("checkDateField(%q): %v", c.key, err)
+		case c.msg != "" && (err == nil || !strings.Contains(err.Error(), c.msg)):
+			t.Errorf("checkDateField(%q) error = %v, want contains %q", c.key, err, c.msg)
+		}
+	}
+}
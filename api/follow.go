@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// follow 类似 tail -f ：每隔 arg.FollowStep 查询一次时间字段从上次最后一条记录到现在的日志，按时间升序输出。
+// 每次查询都往前多查 arg.Lag ，以容忍延迟写入的日志；重复的记录通过指纹去重。
+func follow(logdbClient *logdb.LogdbAPI, conf *Config, repoInfo *logdb.GetRepoOutput,
+	where string, dateField string, arg *CtlArg) (err error) {
+	if len(dateField) == 0 {
+		return errors.New("ERROR: follow 需要时间字段，repo 没有 date 类型字段，请使用 --dateField 指定")
+	}
+	interval := arg.FollowStep
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	sort := dateField + ":asc"
+	last := *arg.Start             // 已输出的最后一条记录的时间
+	seen := map[uint64]time.Time{} // [last - lag, last] 内已输出的记录
+	total := 0
+	for {
+		lo := last.Add(-arg.Lag).Truncate(time.Second)
+		if lo.Before(*arg.Start) {
+			lo = *arg.Start
+		}
+		query := dateField + ":[" + lo.Format(DateLayout) + " TO *]"
+		if len(where) != 0 {
+			query = "(" + where + ") AND " + query
+		}
+		c := newDateCursor(query, dateField, true)
+		from := 0
+		for {
+			q := c.pageQuery()
+			var logs *logdb.QueryLogOutput
+			logs, err = doQuery(logdbClient, conf, &q, sort, from, arg.PreSize, false)
+			if err != nil {
+				return
+			}
+			if logs.PartialSuccess {
+				log.Warn("部分分片查询失败 (PartialSuccess)，结果可能不完整")
+			}
+			data, ok := c.advance(logs.Data)
+			if !ok {
+				return errors.New("ERROR: 记录缺少可解析的时间字段 " + dateField)
+			}
+			fresh := make([]map[string]interface{}, 0, len(data))
+			for _, v := range data {
+				fp := fingerprint(v)
+				if _, ok := seen[fp]; ok {
+					continue
+				}
+				t, _ := recordTime(v[dateField])
+				seen[fp] = t
+				if t.After(last) {
+					last = t
+				}
+				fresh = append(fresh, v)
+			}
+			fresh = limitData(fresh, arg.Limit, total)
+			showLogs(conf, repoInfo, &logdb.QueryLogOutput{Data: fresh}, arg, total+1)
+			total += len(fresh)
+			if arg.Limit > 0 && total >= arg.Limit {
+				return
+			}
+			if len(logs.Data) < arg.PreSize {
+				break
+			}
+			// 同一秒内的记录超过一页时，游标无法前进，跳过这一秒内已输出的记录继续翻页
+			if len(data) == 0 {
+				from = MaxInt(from+len(logs.Data), c.sameSecond())
+			} else {
+				from = 0
+			}
+		}
+		// 只需保留 lag 窗口内的记录
+		expire := last.Add(-arg.Lag).Add(-time.Second)
+		for fp, t := range seen {
+			if t.Before(expire) {
+				delete(seen, fp)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+type HistogramArg struct {
+	Interval    time.Duration // 分桶间隔，0 表示自动选择
+	Format      string        // table csv json bar spark
+	Concurrency int           // 没有服务端直方图时，并发查询各桶条数的并发数
+}
+
+type histBucket struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+}
+
+// Histogram 按时间分桶统计满足条件的日志条数
+func Histogram(conf *Config, query string, arg *CtlArg, harg *HistogramArg) (err error) {
+	switch harg.Format {
+	case "table", "csv", "json", "bar", "spark":
+	default:
+		return fmt.Errorf("ERROR: 不支持的格式 %s ，可选 table csv json bar spark", harg.Format)
+	}
+	logdbClient, err := buildClient(conf)
+	if err != nil {
+		return
+	}
+	repoInfo, err := getRepoInfo(logdbClient, conf)
+	if err != nil {
+		return
+	}
+	if err = checkInRetention(repoInfo, arg); err != nil {
+		return
+	}
+	dateField, _, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
+	if err != nil {
+		return
+	}
+	if len(dateField) == 0 {
+		return errors.New("ERROR: histogram 需要时间字段，repo 没有 date 类型字段，请使用 --dateField 指定")
+	}
+	buckets := newHistBuckets(*arg.Start, *arg.End, harg.Interval)
+	if !serverHistogram(logdbClient, conf, query, dateField, buckets) {
+		err = countHistogram(logdbClient, conf, query, dateField, buckets, harg.Concurrency)
+		if err != nil {
+			return
+		}
+	}
+	return showHistogram(buckets, harg.Format)
+}
+
+func newHistBuckets(start, end time.Time, interval time.Duration) []histBucket {
+	start = start.Truncate(time.Second)
+	if interval <= 0 {
+		interval = autoInterval(start, end, 60)
+	}
+	var buckets []histBucket
+	for t := start; t.Before(end); t = t.Add(interval) {
+		e := t.Add(interval)
+		if e.After(end) {
+			e = end
+		}
+		buckets = append(buckets, histBucket{Start: t, End: e})
+	}
+	if len(buckets) == 0 {
+		buckets = append(buckets, histBucket{Start: start, End: end})
+	}
+	return buckets
+}
+
+// serverHistogram 使用服务端直方图接口，服务端的桶合并到 buckets 中。
+// 接口出错，或服务端的桶比 buckets 粗、无法合并时返回 false
+func serverHistogram(logdbClient *logdb.LogdbAPI, conf *Config, query string,
+	dateField string, buckets []histBucket) bool {
+	start := buckets[0].Start
+	end := buckets[len(buckets)-1].End
+	out, err := (*logdbClient).QueryHistogramLog(&logdb.QueryHistogramLogInput{
+		RepoName: conf.Repo[0],
+		Query:    query,
+		From:     start.UnixNano() / int64(time.Millisecond),
+		To:       end.UnixNano() / int64(time.Millisecond),
+		Field:    dateField,
+	})
+	if err != nil {
+		log.Debugf("QueryHistogramLog: %v, fallback to count queries\n", err)
+		return false
+	}
+	if out.PartialSuccess {
+		log.Warn("部分分片查询失败 (PartialSuccess)，结果可能不完整")
+	}
+	interval := buckets[0].End.Sub(buckets[0].Start)
+	width := interval
+	if len(out.Buckets) > 1 {
+		width = time.Duration(out.Buckets[1].Key-out.Buckets[0].Key) * time.Millisecond
+		if width <= 0 || width > interval || interval%width != 0 {
+			log.Debugf("QueryHistogramLog: bucket width %v, interval %v, fallback to count queries\n", width, interval)
+			return false
+		}
+	}
+	for _, b := range out.Buckets {
+		t := time.Unix(0, b.Key*int64(time.Millisecond))
+		// 服务端的桶需与 buckets 的边界对齐，否则无法准确合并
+		if t.Sub(start)%width != 0 {
+			log.Debugf("QueryHistogramLog: bucket %v not aligned to %v, fallback to count queries\n", t, start)
+			return false
+		}
+		if t.Before(start) {
+			continue
+		}
+		i := int(t.Sub(start) / interval)
+		if i >= len(buckets) {
+			continue
+		}
+		buckets[i].Count += b.Count
+	}
+	return true
+}
+
+// countHistogram 并发查询各桶的条数
+func countHistogram(logdbClient *logdb.LogdbAPI, conf *Config, query string,
+	dateField string, buckets []histBucket, concurrency int) error {
+	queries := make([]string, len(buckets))
+	for i, b := range buckets {
+		queries[i] = "(" + query + ") AND " + dateField + ":[" + b.Start.Format(DateLayout) +
+			" TO " + b.End.Format(DateLayout) + "}"
+	}
+	// 最后一个桶包含结束时间，与 buildQueryStr 的范围一致
+	last := len(buckets) - 1
+	queries[last] = strings.TrimSuffix(queries[last], "}") + "]"
+	counts, err := countBuckets(logdbClient, conf, queries, concurrency)
+	if err != nil {
+		return err
+	}
+	for i, n := range counts {
+		buckets[i].Count = n
+	}
+	return nil
+}
+
+func showHistogram(buckets []histBucket, format string) error {
+	switch format {
+	case "csv":
+		fmt.Println("start,end,count")
+		for _, b := range buckets {
+			fmt.Printf("%s,%s,%d\n", b.Start.Format(DateLayout), b.End.Format(DateLayout), b.Count)
+		}
+	case "json":
+		data, err := json.MarshalIndent(buckets, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "bar":
+		max := maxCount(buckets)
+		for _, b := range buckets {
+			fmt.Printf("%s  %-50s %d\n", b.Start.Format(DateLayout), strings.Repeat("#", scale(b.Count, max, 50)), b.Count)
+		}
+	case "spark":
+		counts := make([]int, len(buckets))
+		total := 0
+		for i, b := range buckets {
+			counts[i] = b.Count
+			total += b.Count
+		}
+		fmt.Printf("%s ~ %s  %s  total=%d max=%d\n", buckets[0].Start.Format(DateLayout),
+			buckets[len(buckets)-1].End.Format(DateLayout), sparkline(counts), total, maxCount(buckets))
+	default:
+		for _, b := range buckets {
+			fmt.Printf("%s\t%d\n", b.Start.Format(DateLayout), b.Count)
+		}
+	}
+	return nil
+}
+
+func maxCount(buckets []histBucket) int {
+	max := 0
+	for _, b := range buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	return max
+}
+
+// scale 将 n 按 max 缩放到 [0, width]，非 0 的值至少为 1
+func scale(n, max, width int) int {
+	if max <= 0 || n <= 0 {
+		return 0
+	}
+	w := n * width / max
+	if w == 0 {
+		w = 1
+	}
+	return w
+}
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+func sparkline(counts []int) string {
+	max := 0
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
+	}
+	runes := make([]rune, len(counts))
+	for i, n := range counts {
+		if max == 0 {
+			runes[i] = sparkTicks[0]
+			continue
+		}
+		runes[i] = sparkTicks[n*(len(sparkTicks)-1)/max]
+	}
+	return string(runes)
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+	"github.com/qiniuts/qlogctl/query"
+)
+
+type LocalArg struct {
+	Inputs    []string // 每行一条 json 的文件，.gz 结尾的按 gzip 解压，- 表示 stdin
+	KeepOrder bool     // 未指定排序时，按文件中的顺序输出
+}
+
+// LocalQuery 在导出的 jsonl 文件中查询，不访问 logdb 。
+// 查询语法、时间范围、显示的字段、排序和输出格式与 query 相同
+func LocalQuery(q string, arg *CtlArg, larg *LocalArg) (err error) {
+	if err = checkFormat(arg.Format); err != nil {
+		return
+	}
+	if len(larg.Inputs) == 0 {
+		return errors.New("ERROR: 请使用 --input 指定文件")
+	}
+	node, err := query.Parse(q)
+	if err != nil {
+		return errors.New(query.Describe(q, err))
+	}
+
+	manifest := readLocalManifest(larg.Inputs[0])
+	for _, input := range larg.Inputs {
+		if m := readLocalManifest(input); m != nil && len(m.Format) != 0 && m.Format != "json" {
+			return fmt.Errorf("ERROR: %s 为 %s 格式，local-query 只能查询 json 格式导出的文件，请使用 query --format json -o 重新导出", input, m.Format)
+		}
+	}
+	// 有 manifest 时先按其 schema 检查，避免扫描完全部文件才发现查询语句有误；
+	// 否则需扫描后推断 schema 再检查
+	repoInfo := &logdb.GetRepoOutput{}
+	var dateField string
+	var spec SortSpec
+	if manifest != nil {
+		repoInfo.Schema = manifest.Schema
+		if dateField, spec, err = checkLocalQuery(q, node, repoInfo.Schema, manifest, arg, larg); err != nil {
+			return
+		}
+	}
+	infer := newSchemaInfer()
+	// 每个文件满足条件的记录
+	lists := make([][]map[string]interface{}, len(larg.Inputs))
+	for i, input := range larg.Inputs {
+		err = scanLocalFile(input, func(v map[string]interface{}) {
+			if manifest == nil {
+				infer.add(v)
+			}
+			if query.Match(node, v) {
+				lists[i] = append(lists[i], v)
+			}
+		})
+		if err != nil {
+			return
+		}
+	}
+	if manifest == nil {
+		repoInfo.Schema = infer.schema()
+		if dateField, spec, err = checkLocalQuery(q, node, repoInfo.Schema, nil, arg, larg); err != nil {
+			return
+		}
+	}
+
+	// 未指定排序时按文件中的顺序输出；否则各文件分别排序后归并
+	for i, l := range lists {
+		if arg.Start != nil && arg.End != nil && len(dateField) != 0 {
+			l = filterTimeRange(l, dateField, arg)
+		}
+		if len(spec) > 0 {
+			sort.SliceStable(l, func(i, j int) bool { return spec.Compare(l[i], l[j]) < 0 })
+		}
+		lists[i] = l
+	}
+	matched := mergeSorted(spec, lists...)
+	if arg.Limit > 0 && len(matched) > arg.Limit {
+		matched = matched[:arg.Limit]
+	}
+
+	if arg.Count {
+		fmt.Printf("total:     %d\n", len(matched))
+		if len(dateField) != 0 && arg.Start != nil && arg.End != nil {
+			fmt.Printf("dateField: %s\n", dateField)
+			fmt.Printf("start:     %s\n", arg.Start.Format(DateLayout))
+			fmt.Printf("end:       %s\n", arg.End.Format(DateLayout))
+		}
+		return
+	}
+	arg.setHighlight(q)
+	showLogs(&Config{}, repoInfo, &logdb.QueryLogOutput{Data: matched}, arg, 1)
+	return
+}
+
+// checkLocalQuery 按 schema 检查查询语句、时间字段和排序，返回使用的时间字段和排序
+func checkLocalQuery(q string, node query.Node, schema []logdb.RepoSchemaEntry, manifest *Manifest,
+	arg *CtlArg, larg *LocalArg) (dateField string, spec SortSpec, err error) {
+	dateField = arg.DateField
+	if len(dateField) != 0 && !arg.NoCheck {
+		if err = checkDateField(schema, dateField); err != nil {
+			return
+		}
+	}
+	if len(dateField) == 0 && manifest != nil {
+		dateField = manifest.DateField
+	}
+	if len(dateField) == 0 {
+		var reason string
+		dateField, reason = chooseDateField(schema, "")
+		log.Debugf("dateField: %s (%s)\n", dateField, reason)
+	}
+	if node != nil && !arg.NoCheck {
+		if err = query.Validate(node, schema); err != nil {
+			err = errors.New(query.Describe(q, err) + "\n如确认查询语句无误，可使用 --noCheck 跳过检查")
+			return
+		}
+	}
+	if larg.KeepOrder {
+		return
+	}
+	if spec, err = buildSort(arg, dateField); err != nil {
+		return
+	}
+	if !arg.NoCheck && (len(arg.Sort) > 0 || len(arg.OrderField) > 0) {
+		err = spec.Validate(schema)
+	}
+	return
+}
+
+// readLocalManifest 读取导出时生成的 manifest ，其中有 schema 和时间字段。没有时返回 nil
+func readLocalManifest(input string) *Manifest {
+	for _, path := range []string{input + manifestSuffix, strings.TrimSuffix(input, ".gz") + manifestSuffix} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var m Manifest
+		if json.Unmarshal(data, &m) == nil && len(m.Schema) > 0 {
+			return &m
+		}
+	}
+	return nil
+}
+
+// scanLocalFile 逐行解析文件中的 json
+func scanLocalFile(input string, fn func(v map[string]interface{})) (err error) {
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if strings.HasSuffix(input, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("ERROR: %s 不是 gzip 文件 : %v", input, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	br := bufio.NewReaderSize(r, 1024*1024)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var v map[string]interface{}
+			if err1 := json.Unmarshal(line, &v); err1 != nil {
+				return fmt.Errorf("ERROR: %s 第 %d 行不是 json : %v\nlocal-query 只能查询 query --format json 导出的文件", input, lineNo, err1)
+			}
+			fn(v)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func filterTimeRange(data []map[string]interface{}, dateField string, arg *CtlArg) []map[string]interface{} {
+	kept := data[:0]
+	for _, v := range data {
+		t, ok := recordTime(v[dateField])
+		if ok && !t.Before(*arg.Start) && !t.After(*arg.End) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// compareValues 比较两个字段值：数值按数值，时间按时间，其余按字符串。没有值的排在最后
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := recordTime(a); ok {
+		if y, ok := recordTime(b); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// schemaInfer 根据记录的值推断字段类型
+type schemaInfer struct {
+	types  map[string]string
+	nested map[string]*schemaInfer
+}
+
+func newSchemaInfer() *schemaInfer {
+	return &schemaInfer{types: map[string]string{}, nested: map[string]*schemaInfer{}}
+}
+
+func (s *schemaInfer) add(v map[string]interface{}) {
+	for k, value := range v {
+		t := valueType(value)
+		if t == "" {
+			continue
+		}
+		if sub, ok := value.(map[string]interface{}); ok {
+			if s.nested[k] == nil {
+				s.nested[k] = newSchemaInfer()
+			}
+			s.nested[k].add(sub)
+		}
+		s.types[k] = mergeType(s.types[k], t)
+	}
+}
+
+func valueType(v interface{}) string {
+	switch x := v.(type) {
+	case float64:
+		if x == math.Trunc(x) {
+			return "long"
+		}
+		return "float"
+	case bool:
+		return "boolean"
+	case string:
+		if _, ok := recordTime(x); ok {
+			return "date"
+		}
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		for _, e := range x {
+			if t := valueType(e); t != "" {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// mergeType 同一字段出现不同类型的值时，取能包含两者的类型
+func mergeType(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case a == "long" && b == "float" || a == "float" && b == "long":
+		return "float"
+	}
+	return "string"
+}
+
+func (s *schemaInfer) schema() []logdb.RepoSchemaEntry {
+	keys := make([]string, 0, len(s.types))
+	for k := range s.types {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	schema := make([]logdb.RepoSchemaEntry, 0, len(keys))
+	for _, k := range keys {
+		e := logdb.RepoSchemaEntry{Key: k, ValueType: s.types[k]}
+		if sub := s.nested[k]; sub != nil && e.ValueType == "object" {
+			e.Schemas = sub.schema()
+		}
+		schema = append(schema, e)
+	}
+	return schema
+}
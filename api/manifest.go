@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+const manifestSuffix = ".manifest.json"
+
+// Manifest 导出结果的清单，与数据文件放在一起，用于审计和校验导出内容
+type Manifest struct {
+	Repo       string                  `json:"repo"`
+	Query      string                  `json:"query"` // 实际查询语句，含时间范围
+	DateField  string                  `json:"dateField,omitempty"`
+	Start      string                  `json:"start"`
+	End        string                  `json:"end"`
+	Sort       string                  `json:"sort,omitempty"`
+	Format     string                  `json:"format,omitempty"` // 数据文件的格式 text json csv
+	Schema     []logdb.RepoSchemaEntry `json:"schema"`
+	Records    int                     `json:"records"`
+	Partial    int                     `json:"partialPages"` // 部分分片查询失败且未能重试成功的页数
+	Backfilled int                     `json:"backfilled"`
+	CreateTime string                  `json:"createTime"`
+	Files      []ManifestFile          `json:"files"`
+}
+
+type ManifestFile struct {
+	Path           string `json:"path"` // 相对 manifest 所在目录的路径
+	Records        int    `json:"records"`
+	HeaderLines    int    `json:"headerLines,omitempty"` // csv 格式的表头行数
+	FirstTimestamp string `json:"firstTimestamp,omitempty"`
+	LastTimestamp  string `json:"lastTimestamp,omitempty"`
+	Bytes          int64  `json:"bytes"`
+	Sha256         string `json:"sha256"`
+}
+
+// exportFile 导出的数据文件，写入时同时计算字节数和 sha256
+type exportFile struct {
+	path    string
+	f       *os.File
+	w       *bufio.Writer
+	hash    hash.Hash
+	bytes   int64
+	records int
+	header  int // 表头行数
+	first   string
+	last    string
+}
+
+func createExportFile(path string) (*exportFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &exportFile{
+		path: path,
+		f:    f,
+		w:    bufio.NewWriter(f),
+		hash: sha256.New(),
+	}, nil
+}
+
+func (e *exportFile) Write(p []byte) (n int, err error) {
+	n, err = e.w.Write(p)
+	e.hash.Write(p[:n])
+	e.bytes += int64(n)
+	return
+}
+
+// track 记录已写入的记录条数及首尾记录的时间
+func (e *exportFile) track(data []map[string]interface{}, dateField string) {
+	if e == nil || len(data) == 0 {
+		return
+	}
+	e.records += len(data)
+	if len(dateField) == 0 {
+		return
+	}
+	if e.first == "" {
+		e.first = fmt.Sprint(data[0][dateField])
+	}
+	e.last = fmt.Sprint(data[len(data)-1][dateField])
+}
+
+func (e *exportFile) Close() error {
+	err := e.w.Flush()
+	if err1 := e.f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (e *exportFile) manifestFile() ManifestFile {
+	return ManifestFile{
+		Path:           filepath.Base(e.path),
+		Records:        e.records,
+		HeaderLines:    e.header,
+		FirstTimestamp: e.first,
+		LastTimestamp:  e.last,
+		Bytes:          e.bytes,
+		Sha256:         hex.EncodeToString(e.hash.Sum(nil)),
+	}
+}
+
+// writeManifest 在数据文件旁写入 <output>.manifest.json
+func writeManifest(conf *Config, repoInfo *logdb.GetRepoOutput, query string, dateField string,
+	sort string, arg *CtlArg, summary *exportSummary) error {
+	if err := arg.export.w.Flush(); err != nil {
+		return err
+	}
+	m := Manifest{
+		Repo:       conf.Repo[0],
+		Query:      query,
+		DateField:  dateField,
+		Start:      arg.Start.Format(DateLayout),
+		End:        arg.End.Format(DateLayout),
+		Sort:       sort,
+		Format:     arg.Format,
+		Schema:     repoInfo.Schema,
+		Records:    arg.export.records,
+		Partial:    summary.unresolved(),
+		Backfilled: summary.backfilled,
+		CreateTime: time.Now().In(location).Format(DateLayout),
+		Files:      []ManifestFile{arg.export.manifestFile()},
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(arg.export.path+manifestSuffix, append(data, '\n'), 0644)
+}
+
+// VerifyManifest 重新计算 manifest 中各文件的字节数、行数和 sha256 ，与记录的值比较
+func VerifyManifest(path string) (err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("manifest 格式不正确 %s : %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	failed := 0
+	for _, mf := range m.Files {
+		msg := checkManifestFile(filepath.Join(dir, mf.Path), mf)
+		if msg != "" {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", mf.Path, msg)
+		} else {
+			fmt.Printf("OK    %s: %d records, %d bytes\n", mf.Path, mf.Records, mf.Bytes)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("ERROR: %d/%d 个文件校验失败", failed, len(m.Files))
+	}
+	if len(m.Files) == 0 {
+		return errors.New("ERROR: manifest 中没有文件")
+	}
+	return
+}
+
+func checkManifestFile(path string, mf ManifestFile) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	h := sha256.New()
+	lines := 0
+	var size int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			size += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err.Error()
+		}
+	}
+	if size != mf.Bytes {
+		return fmt.Sprintf("bytes %d, manifest %d", size, mf.Bytes)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != mf.Sha256 {
+		return fmt.Sprintf("sha256 %s, manifest %s", sum, mf.Sha256)
+	}
+	// 每条记录一行，记录中的换行已转义
+	if lines-mf.HeaderLines != mf.Records {
+		return fmt.Sprintf("records %d, manifest %d", lines-mf.HeaderLines, mf.Records)
+	}
+	return ""
+}
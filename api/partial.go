@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+const partialRetries = 5
+
+// partialPage 部分分片查询失败 (PartialSuccess) 的页
+type partialPage struct {
+	page     int  // 第几页，从 1 开始
+	from     int  // 该页第一条记录的序号，从 1 开始
+	size     int  // 该页条数
+	resolved bool // 重试后已完整获取
+}
+
+// exportSummary 汇总导出结果，导出结束时输出到 stderr
+type exportSummary struct {
+	records    int
+	pages      int
+	partial    []partialPage
+	backfilled int // verify 后补充输出的条数
+	start      time.Time
+}
+
+func newExportSummary() *exportSummary {
+	return &exportSummary{start: time.Now()}
+}
+
+func (s *exportSummary) add(page scrollPage, size int) {
+	s.pages++
+	if page.partial || page.retried {
+		p := partialPage{page: s.pages, from: s.records + 1, size: size, resolved: !page.partial}
+		s.partial = append(s.partial, p)
+		if p.resolved {
+			log.Warnf("第 %d 页（第 %d 条起，共 %d 条）部分分片查询失败 (PartialSuccess)，已重试并完整获取\n", p.page, p.from, p.size)
+		} else {
+			log.Warnf("第 %d 页（第 %d 条起，共 %d 条）部分分片查询失败 (PartialSuccess)，结果不完整。可使用 --retry-partial 重试，或 --strict 直接报错\n", p.page, p.from, p.size)
+		}
+	}
+	s.records += size
+}
+
+func (s *exportSummary) unresolved() int {
+	n := 0
+	for _, p := range s.partial {
+		if !p.resolved {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *exportSummary) print() {
+	fmt.Fprintf(os.Stderr, "summary records=%d pages=%d partial=%d unresolved=%d backfilled=%d elapsed=%s\n",
+		s.records, s.pages, len(s.partial), s.unresolved(), s.backfilled, time.Since(s.start).Truncate(time.Second))
+	for _, p := range s.partial {
+		fmt.Fprintf(os.Stderr, "partial page=%d from=%d size=%d resolved=%v\n", p.page, p.from, p.size, p.resolved)
+	}
+}
+
+// requery 查询结果为 PartialSuccess 时重新查询，直到完整返回或达到重试次数
+func requery(fn func() (*logdb.QueryLogOutput, error)) (logs *logdb.QueryLogOutput, retried bool, err error) {
+	logs, err = fn()
+	for i := 0; i < partialRetries && err == nil && logs.PartialSuccess; i++ {
+		log.Debugf("PartialSuccess, retry %d\n", i+1)
+		retried = true
+		time.Sleep(time.Duration(i+1) * 2 * time.Second)
+		logs, err = fn()
+	}
+	return
+}
+
+// sliceRetry 按时间字段排序的 scroll 页部分失败时，scroll 无法重新拉取同一页，
+// 改为重新完整查询该页覆盖的时间段。与相邻页重复的记录通过 cursor 去重。
+type sliceRetry struct {
+	query  string
+	sort   string
+	cursor *dateCursor
+}
+
+func newSliceRetry(query string, dateField string, sort string, asc bool) *sliceRetry {
+	return &sliceRetry{
+		query:  query,
+		sort:   sort,
+		cursor: newDateCursor(query, dateField, asc),
+	}
+}
+
+// dedupe 去掉与之前页重复的记录，每页都需经过此处
+func (r *sliceRetry) dedupe(data []map[string]interface{}) []map[string]interface{} {
+	fresh, ok := r.cursor.advance(data)
+	if !ok {
+		return data
+	}
+	return fresh
+}
+
+// refetch 重新查询部分失败的页覆盖的时间段：从上一页最后一秒到下一页 next 第一秒，
+// 没有上一页/下一页时为 data 最早一秒/不限。缺失的记录可能在 data 与下一页之间，因此以下一页为界
+func (r *sliceRetry) refetch(logdbClient *logdb.LogdbAPI, conf *Config,
+	data []map[string]interface{}, next []map[string]interface{}) (out []map[string]interface{}, partial bool, err error) {
+	field := r.cursor.dateField
+	lo, hi := "*", "*"
+	from := r.cursor.boundary
+	if from.IsZero() && len(data) > 0 {
+		t, ok := recordTime(data[0][field])
+		if !ok {
+			// 无法确定时间段，保留原结果
+			return data, true, nil
+		}
+		from = t.Truncate(time.Second)
+	}
+	var to time.Time
+	if len(next) > 0 {
+		t, ok := recordTime(next[0][field])
+		if !ok {
+			return data, true, nil
+		}
+		to = t.Truncate(time.Second)
+	}
+	// 都按秒取整，边界这一秒完整包含在内
+	if r.cursor.asc {
+		if !from.IsZero() {
+			lo = from.Format(DateLayout)
+		}
+		if !to.IsZero() {
+			hi = to.Add(time.Second).Format(DateLayout)
+		}
+	} else {
+		if !from.IsZero() {
+			hi = from.Add(time.Second).Format(DateLayout)
+		}
+		if !to.IsZero() {
+			lo = to.Format(DateLayout)
+		}
+	}
+	slice := "(" + r.query + ") AND " + field + ":[" + lo + " TO " + hi + "}"
+	log.Debugf("retry partial page: %s\n", slice)
+	c := newDateCursor(slice, field, r.cursor.asc)
+	for {
+		q := c.pageQuery()
+		logs, _, e := requery(func() (*logdb.QueryLogOutput, error) {
+			return doQuery(logdbClient, conf, &q, r.sort, 0, autoMaxSize, false)
+		})
+		if e != nil {
+			return nil, false, e
+		}
+		partial = partial || logs.PartialSuccess
+		fresh, _ := c.advance(logs.Data)
+		out = append(out, fresh...)
+		if len(logs.Data) < autoMaxSize || len(fresh) == 0 {
+			return
+		}
+	}
+}
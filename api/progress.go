@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	barWidth         = 30
+	ttyRefresh       = 500 * time.Millisecond
+	progressInterval = 10 * time.Second
+)
+
+// progress 记录 scroll 导出的进度，输出到 stderr 。
+// stderr 为终端时显示进度条；否则定期输出一行 key=value 格式的进度，便于程序解析。
+// 收到 SIGUSR1 时，立即输出一次当前进度。
+type progress struct {
+	sync.Mutex
+	w       io.Writer
+	tty     bool
+	total   int   // 预计总条数，即首次查询返回的 Total
+	records int   // 已输出条数
+	bytes   int64 // 已输出字节数
+	start   time.Time
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+func newProgress(total int) *progress {
+	p := &progress{
+		w:     os.Stderr,
+		tty:   isTerminal(os.Stderr),
+		total: total,
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.loop()
+	return p
+}
+
+func (p *progress) add(records int, bytes int) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	p.records += records
+	p.bytes += int64(bytes)
+	p.Unlock()
+}
+
+// finish 停止刷新，并输出最终进度。可多次调用
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.once.Do(p.stop)
+}
+
+func (p *progress) stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.Lock()
+	defer p.Unlock()
+	if p.tty {
+		fmt.Fprintf(p.w, "\r%s\n", p.bar())
+	} else {
+		fmt.Fprintln(p.w, p.line())
+	}
+}
+
+func (p *progress) loop() {
+	defer p.wg.Done()
+	interval := progressInterval
+	if p.tty {
+		interval = ttyRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sig := make(chan os.Signal, 1)
+	notifySnapshot(sig)
+	defer stopSnapshot(sig)
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.Lock()
+			if p.tty {
+				fmt.Fprintf(p.w, "\r%s", p.bar())
+			} else {
+				fmt.Fprintln(p.w, p.line())
+			}
+			p.Unlock()
+		case <-sig:
+			p.Lock()
+			if p.tty {
+				// 另起一行，保留当时的快照，进度条在下一次刷新时重新绘制
+				fmt.Fprintf(p.w, "\r%s\n", p.line())
+			} else {
+				fmt.Fprintln(p.w, p.line())
+			}
+			p.Unlock()
+		}
+	}
+}
+
+// 以下方法调用前需持有锁
+
+func (p *progress) elapsed() time.Duration {
+	return time.Since(p.start)
+}
+
+func (p *progress) rate() float64 {
+	sec := p.elapsed().Seconds()
+	if sec <= 0 {
+		return 0
+	}
+	return float64(p.records) / sec
+}
+
+func (p *progress) percent() float64 {
+	if p.total <= 0 {
+		return 0
+	}
+	pct := float64(p.records) * 100 / float64(p.total)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+// eta 预计剩余时间，无法估算时返回 -1
+func (p *progress) eta() time.Duration {
+	rate := p.rate()
+	if rate <= 0 || p.total <= 0 {
+		return -1
+	}
+	left := p.total - p.records
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(float64(left) / rate * float64(time.Second))
+}
+
+func (p *progress) bar() string {
+	pct := p.percent()
+	filled := int(pct / 100 * barWidth)
+	b := strings.Repeat("=", filled)
+	if filled < barWidth {
+		b += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %5.1f%% %d/%d %.0f rec/s %s ETA %s ",
+		b, pct, p.records, p.total, p.rate(), formatBytes(p.bytes), formatETA(p.eta()))
+}
+
+func (p *progress) line() string {
+	return fmt.Sprintf("progress records=%d total=%d percent=%.2f bytes=%d rate=%.1f elapsed=%s eta=%s",
+		p.records, p.total, p.percent(), p.bytes, p.rate(),
+		p.elapsed().Truncate(time.Second), formatETA(p.eta()))
+}
+
+func formatETA(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
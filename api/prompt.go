@@ -0,0 +1,26 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirm 在 stderr 提示并从 stdin 读取确认。stdin 不是终端时直接返回错误，需使用 --yes 跳过确认
+func confirm(msg string) error {
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("ERROR: %s 请使用 --yes 确认拉取", msg)
+	}
+	fmt.Fprintf(os.Stderr, "%s 是否继续？[y/N] ", msg)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("ERROR: 已取消")
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// parseRetention 解析 repo 的数据保存时间，如 7d 30d 12h ，没有单位时按天；-1 表示永久保存
+func parseRetention(retention string) (d time.Duration, forever bool, err error) {
+	s := strings.ToLower(strings.TrimSpace(retention))
+	if s == "-1" {
+		forever = true
+		return
+	}
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n <= 0 {
+		err = fmt.Errorf("不能识别的保存时间 %q", retention)
+		return
+	}
+	switch strings.TrimSpace(s[i:]) {
+	case "", "d", "day", "days":
+		d = time.Duration(n) * 24 * time.Hour
+	case "h", "hour", "hours":
+		d = time.Duration(n) * time.Hour
+	case "w", "week", "weeks":
+		d = time.Duration(n) * 7 * 24 * time.Hour
+	default:
+		err = fmt.Errorf("不能识别的保存时间 %q", retention)
+	}
+	return
+}
+
+// formatRetention 显示的保存时间
+func formatRetention(retention string) string {
+	d, forever, err := parseRetention(retention)
+	switch {
+	case err != nil:
+		return retention
+	case forever:
+		return "永久"
+	case d%(24*time.Hour) == 0:
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	}
+	return strconv.Itoa(int(d/time.Hour)) + "h"
+}
+
+// retentionStatus 时间范围与 repo 保存时间的关系：earliest 为保存时间内最早的时间，
+// partly 表示开始时间早于 earliest ，entirely 表示整个范围都早于 earliest 。
+// ok 为 false 表示无法检查：没有时间范围、保存时间不能识别或永久保存
+func retentionStatus(repoInfo *logdb.GetRepoOutput, arg *CtlArg) (earliest time.Time, partly, entirely, ok bool) {
+	if repoInfo == nil || arg.Start == nil || arg.End == nil {
+		return
+	}
+	d, forever, err := parseRetention(repoInfo.Retention)
+	if err != nil {
+		log.Debugf("%v ，不检查时间范围\n", err)
+		return
+	}
+	if forever {
+		return
+	}
+	earliest = time.Now().In(location).Add(-d)
+	partly = earliest.After(*arg.Start)
+	entirely = earliest.After(*arg.End)
+	return earliest, partly, entirely, true
+}
+
+// checkInRetention 检查时间范围是否超出 repo 的保存时间。超出时默认输出警告；
+// StrictRange 时报错退出；ClampRange 时将开始时间调整为保存时间内最早的时间
+func checkInRetention(repoInfo *logdb.GetRepoOutput, arg *CtlArg) (err error) {
+	earliest, partly, entirely, ok := retentionStatus(repoInfo, arg)
+	if !ok || !partly {
+		return
+	}
+	retention := formatRetention(repoInfo.Retention)
+	var msg string
+	if entirely {
+		msg = fmt.Sprintf("时间范围 [%s ~ %s] 全部早于 repo 的保存时间 %s（最早 %s），查询不到数据",
+			arg.Start.Format(DateLayout), arg.End.Format(DateLayout), retention, earliest.Format(DateLayout))
+		if arg.StrictRange || arg.ClampRange {
+			return errors.New("ERROR: " + msg)
+		}
+		log.Warn(msg)
+		return
+	}
+	msg = fmt.Sprintf("开始时间 %s 早于 repo 的保存时间 %s（最早 %s），之前的数据已过期",
+		arg.Start.Format(DateLayout), retention, earliest.Format(DateLayout))
+	switch {
+	case arg.StrictRange:
+		return errors.New("ERROR: " + msg + "\n可使用 --clamp-range 将开始时间调整到保存时间内")
+	case arg.ClampRange:
+		log.Warnf("%s ，开始时间调整为 %s\n", msg, earliest.Format(DateLayout))
+		arg.Start = &earliest
+	default:
+		log.Warn(msg)
+	}
+	return
+}
+
+// explainRetention --explain 中显示的保存时间及时间范围是否超出
+func explainRetention(repoInfo *logdb.GetRepoOutput, arg *CtlArg) string {
+	if repoInfo == nil {
+		return "<unknown>"
+	}
+	s := formatRetention(repoInfo.Retention)
+	earliest, partly, entirely, ok := retentionStatus(repoInfo, arg)
+	switch {
+	case !ok || !partly:
+		return s
+	case entirely:
+		return s + " (时间范围全部早于保存时间，最早 " + earliest.Format(DateLayout) + " ，查询不到数据)"
+	case arg.ClampRange:
+		return s + " (开始时间早于保存时间，--clamp-range 时调整为 " + earliest.Format(DateLayout) + ")"
+	}
+	return s + " (开始时间早于保存时间，最早 " + earliest.Format(DateLayout) + ")"
+}
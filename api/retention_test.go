@@ -0,0 +1,142 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+func TestParseRetention(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		in      string
+		want    time.Duration
+		forever bool
+	}{
+		{"7d", 7 * day, false},
+		{"30", 30 * day, false},
+		{" 7 Days ", 7 * day, false},
+		{"12h", 12 * time.Hour, false},
+		{"1hour", time.Hour, false},
+		{"2w", 14 * day, false},
+		{"-1", 0, true},
+	}
+	for _, c := range cases {
+		d, forever, err := parseRetention(c.in)
+		if err != nil {
+			t.Errorf("parseRetention(%q): %v", c.in, err)
+			continue
+		}
+		if d != c.want || forever != c.forever {
+			t.Errorf("parseRetention(%q) = %v, %v, want %v, %v", c.in, d, forever, c.want, c.forever)
+		}
+	}
+	for _, in := range []string{"", "d", "0d", "-2", "7x", "7m", "forever", "1.5d"} {
+		if _, _, err := parseRetention(in); err == nil {
+			t.Errorf("parseRetention(%q): expected error", in)
+		}
+	}
+}
+
+func TestFormatRetention(t *testing.T) {
+	for in, want := range map[string]string{
+		"7":     "7d",
+		"7days": "7d",
+		"48h":   "2d",
+		"36h":   "36h",
+		"1w":    "7d",
+		"-1":    "永久",
+		"bad":   "bad",
+	} {
+		if got := formatRetention(in); got != want {
+			t.Errorf("formatRetention(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCheckInRetention(t *testing.T) {
+	now := time.Now()
+	ago := func(days int) *time.Time {
+		t := now.AddDate(0, 0, -days)
+		return &t
+	}
+	cases := []struct {
+		name        string
+		retention   string
+		start, end  *time.Time
+		strict      bool
+		clamp       bool
+		msg         string // 期望的错误，为空表示不报错
+		clampedDays int    // 开始时间应调整为 clampedDays 天前，0 表示不调整
+	}{
+		{"in range", "7d", ago(3), ago(0), false, false, "", 0},
+		{"in range strict", "7d", ago(3), ago(0), true, false, "", 0},
+		{"forever", "-1", ago(365), ago(0), true, false, "", 0},
+		{"unparseable", "abc", ago(365), ago(0), true, false, "", 0},
+		{"partial warn", "7d", ago(10), ago(0), false, false, "", 0},
+		{"partial strict", "7d", ago(10), ago(0), true, false, "--clamp-range", 0},
+		{"partial clamp", "7d", ago(10), ago(0), false, true, "", 7},
+		{"outside warn", "7d", ago(30), ago(20), false, false, "", 0},
+		{"outside strict", "7d", ago(30), ago(20), true, false, "查询不到数据", 0},
+		{"outside clamp", "7d", ago(30), ago(20), false, true, "查询不到数据", 0},
+	}
+	for _, c := range cases {
+		start, end := *c.start, *c.end
+		arg := &CtlArg{Start: &start, End: &end, StrictRange: c.strict, ClampRange: c.clamp}
+		err := checkInRetention(&logdb.GetRepoOutput{Retention: c.retention}, arg)
+		switch {
+		case c.msg == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.msg != "" && (err == nil || !strings.Contains(err.Error(), c.msg)):
+			t.Errorf("%s: error = %v, want contains %q", c.name, err, c.msg)
+		}
+		if !arg.End.Equal(*c.end) {
+			t.Errorf("%s: end changed to %v", c.name, arg.End)
+		}
+		if c.clampedDays == 0 {
+			if !arg.Start.Equal(*c.start) {
+				t.Errorf("%s: start changed to %v", c.name, arg.Start)
+			}
+			continue
+		}
+		want := now.Add(-time.Duration(c.clampedDays) * 24 * time.Hour)
+		if d := arg.Start.Sub(want); d < 0 || d > time.Minute {
+			t.Errorf("%s: start = %v, want about %v", c.name, arg.Start, want)
+		}
+	}
+	if err := checkInRetention(nil, &CtlArg{Start: ago(30), End: ago(0), StrictRange: true}); err != nil {
+		t.Errorf("nil repo: %v", err)
+	}
+}
+
+func TestExplainRetention(t *testing.T) {
+	now := time.Now()
+	ago := func(days int) *time.Time {
+		t := now.AddDate(0, 0, -days)
+		return &t
+	}
+	repo := &logdb.GetRepoOutput{Retention: "7d"}
+	cases := []struct {
+		arg  *CtlArg
+		want string
+	}{
+		{&CtlArg{Start: ago(3), End: ago(0)}, "7d"},
+		{&CtlArg{Start: ago(10), End: ago(0), StrictRange: true}, "开始时间早于保存时间，最早"},
+		{&CtlArg{Start: ago(10), End: ago(0), ClampRange: true}, "--clamp-range 时调整为"},
+		{&CtlArg{Start: ago(30), End: ago(20), StrictRange: true}, "查询不到数据"},
+	}
+	for _, c := range cases {
+		start := *c.arg.Start
+		if got := explainRetention(repo, c.arg); !strings.HasPrefix(got, "7d") || !strings.Contains(got, c.want) {
+			t.Errorf("explainRetention(%v ~ %v) = %q, want contains %q", c.arg.Start, c.arg.End, got, c.want)
+		}
+		if !c.arg.Start.Equal(start) {
+			t.Errorf("explainRetention should not change start")
+		}
+	}
+	if got := explainRetention(&logdb.GetRepoOutput{Retention: "-1"}, &CtlArg{Start: ago(30), End: ago(0)}); got != "永久" {
+		t.Errorf("explainRetention(-1) = %q, want 永久", got)
+	}
+}
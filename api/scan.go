@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// scanLogs 使用 scroll 拉取满足条件的全部日志，逐页交给 fn 处理，用于在本地聚合。
+// 返回处理的总条数。
+func scanLogs(logdbClient *logdb.LogdbAPI, conf *Config, query string, sort string,
+	arg *CtlArg, fn func(data []map[string]interface{})) (total int, err error) {
+	var first scrollPage
+	first.logs, first.retried, err = requery(func() (*logdb.QueryLogOutput, error) {
+		return doQuery(logdbClient, conf, &query, sort, 0, arg.PreSize, true)
+	})
+	if err != nil {
+		return
+	}
+	first.partial = first.logs.PartialSuccess
+	log.Debugf("FirstQuery: [total:%v, state:%v, size: %v]\n", first.logs.Total, first.logs.PartialSuccess, len(first.logs.Data))
+
+	if !arg.Yes && conf.ConfirmThreshold > 0 && first.logs.Total > conf.ConfirmThreshold {
+		err = confirm(fmt.Sprintf("满足条件的日志共 %d 条，超过 %d 条。", first.logs.Total, conf.ConfirmThreshold))
+		if err != nil {
+			return
+		}
+	}
+	var prog *progress
+	if arg.Progress {
+		prog = newProgress(first.logs.Total)
+		defer prog.finish()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	summary := newExportSummary()
+	for page := range fetchScroll(logdbClient, conf, first, arg, nil, done) {
+		if page.err != nil {
+			return total, page.err
+		}
+		size := len(page.logs.Data)
+		summary.add(page, size)
+		if page.partial && arg.Strict {
+			return total, fmt.Errorf("ERROR: 第 %d 页部分分片查询失败 (PartialSuccess)，结果不完整", summary.pages)
+		}
+		fn(page.logs.Data)
+		prog.add(size, 0)
+		total += size
+	}
+	prog.finish()
+	if n := summary.unresolved(); n > 0 {
+		log.Warnf("%d 页部分分片查询失败 (PartialSuccess)，统计结果可能偏小\n", n)
+	}
+	return
+}
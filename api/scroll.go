@@ -0,0 +1,119 @@
+package api
+
+import (
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+type scrollPage struct {
+	logs    *logdb.QueryLogOutput
+	partial bool // 部分分片查询失败 (PartialSuccess)，结果不完整
+	retried bool // 曾部分失败，已重试
+	err     error
+}
+
+// fetchScroll 从首次查询的结果开始，依次拉取后续 scroll 页，放入通道。
+// 通道容量为 arg.Prefetch ，即在输出当前页的同时，最多预取多少页。
+// retry 不为 nil 时，部分失败的页重新查询该页覆盖的时间段。
+// 出错时发送带 err 的页后结束；done 关闭后停止拉取。
+func fetchScroll(logdbClient *logdb.LogdbAPI, conf *Config, first scrollPage,
+	arg *CtlArg, retry *sliceRetry, done <-chan struct{}) <-chan scrollPage {
+	prefetch := arg.Prefetch
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	pages := make(chan scrollPage, prefetch)
+	go func() {
+		defer close(pages)
+		page := first
+		var next *scrollPage // 部分失败的页需要以下一页为界重新查询，提前拉取的下一页
+		fetched := 0
+		for {
+			// 发送后 logs 归消费方所有，先记下需要的信息
+			logs := page.logs
+			size := len(logs.Data)
+			fetched += size
+			scrollId := logs.ScrollId
+			more := logs.Total > fetched && len(scrollId) > 1 && size > 0 &&
+				(arg.Limit <= 0 || fetched < arg.Limit)
+			if retry != nil {
+				if page.partial {
+					var edge []map[string]interface{}
+					if more {
+						n, err := nextScrollPage(logdbClient, conf, scrollId, fetched)
+						if err != nil {
+							sendErr(pages, done, err)
+							return
+						}
+						next = &n
+						edge = n.logs.Data
+					}
+					data, partial, err := retry.refetch(logdbClient, conf, logs.Data, edge)
+					if err != nil {
+						sendErr(pages, done, err)
+						return
+					}
+					logs.Data = data
+					page.partial = partial
+					page.retried = true
+				}
+				logs.Data = retry.dedupe(logs.Data)
+			}
+			select {
+			case pages <- page:
+			case <-done:
+				return
+			}
+			if !more {
+				return
+			}
+
+			if next != nil {
+				page = *next
+				next = nil
+				continue
+			}
+			var err error
+			page, err = nextScrollPage(logdbClient, conf, scrollId, fetched)
+			if err != nil {
+				sendErr(pages, done, err)
+				return
+			}
+		}
+	}()
+	return pages
+}
+
+func nextScrollPage(logdbClient *logdb.LogdbAPI, conf *Config, scrollId string, fetched int) (page scrollPage, err error) {
+	logs, err := queryScroll(logdbClient, conf, scrollId)
+	if err != nil {
+		return
+	}
+	log.Debugf("scroll: %v, logstotal:%v, state:%v, size: %v, fetched: %v\n", logs.ScrollId, logs.Total, logs.PartialSuccess, len(logs.Data), fetched)
+	return scrollPage{logs: logs, partial: logs.PartialSuccess}, nil
+}
+
+func queryScroll(logdbClient *logdb.LogdbAPI, conf *Config, scrollId string) (logs *logdb.QueryLogOutput, err error) {
+	scrollInput := &logdb.QueryScrollInput{
+		RepoName: conf.Repo[0],
+		ScrollId: scrollId,
+		Scroll:   "8m",
+	}
+	logs, err = (*logdbClient).QueryScroll(scrollInput)
+	if err != nil {
+		// 重试 scroll 查询
+		// scroll 在服务端有有效期，过期后 ScrollId 不再有意义，不能过太久后再重试。
+		// ScrollId 序列化到磁盘没有意义。
+		sleep := []time.Duration{5, 15, 35, 65, 65, 65}
+		for _, s := range sleep {
+			time.Sleep(s * time.Second)
+			logs, err = (*logdbClient).QueryScroll(scrollInput)
+			if err == nil {
+				break
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// Session 交互式查询的会话：复用同一个 client ，并缓存 repo 列表和各 repo 的 schema ，
+// 避免每次查询都重新获取
+type Session struct {
+	conf      *Config
+	client    *logdb.LogdbAPI
+	repos     *logdb.ListReposOutput
+	repoInfos map[string]*logdb.GetRepoOutput
+}
+
+func NewSession(conf *Config) (*Session, error) {
+	client, err := buildClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{conf: conf, client: client, repoInfos: map[string]*logdb.GetRepoOutput{}}, nil
+}
+
+// Repo 当前的 repo
+func (s *Session) Repo() string {
+	if len(s.conf.Repo) == 0 {
+		return ""
+	}
+	return s.conf.Repo[0]
+}
+
+// SetRepo 切换 repo ，repo 不存在时返回错误
+func (s *Session) SetRepo(repo string) error {
+	conf := *s.conf
+	conf.Repo = []string{repo}
+	if _, err := s.repoInfo(&conf); err != nil {
+		return err
+	}
+	s.conf.Repo = conf.Repo
+	return nil
+}
+
+func (s *Session) repoInfo(conf *Config) (*logdb.GetRepoOutput, error) {
+	if info, ok := s.repoInfos[conf.Repo[0]]; ok {
+		return info, nil
+	}
+	info, err := getRepoInfo(s.client, conf)
+	if err != nil {
+		return nil, err
+	}
+	s.repoInfos[conf.Repo[0]] = info
+	return info, nil
+}
+
+// RepoNames 当前账号下所有 repo 的名称，首次调用后缓存
+func (s *Session) RepoNames() ([]string, error) {
+	if s.repos == nil {
+		repos, err := (*s.client).ListRepos(&logdb.ListReposInput{})
+		if err != nil {
+			return nil, err
+		}
+		s.repos = repos
+	}
+	names := make([]string, len(s.repos.Repos))
+	for i, r := range s.repos.Repos {
+		names[i] = r.RepoName
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ShowRepos 列出当前账号下所有的 repo
+func (s *Session) ShowRepos(verbose bool) error {
+	if _, err := s.RepoNames(); err != nil {
+		return err
+	}
+	return showRepos(s.repos, verbose)
+}
+
+// Schema 当前 repo 的字段
+func (s *Session) Schema() ([]logdb.RepoSchemaEntry, error) {
+	if len(s.conf.Repo) == 0 {
+		return nil, nil
+	}
+	info, err := s.repoInfo(s.conf)
+	if err != nil {
+		return nil, err
+	}
+	return info.Schema, nil
+}
+
+// Query 在当前 repo 中查询，同 Query
+func (s *Session) Query(query string, arg *CtlArg) (err error) {
+	if err = checkFormat(arg.Format); err != nil {
+		return
+	}
+	if len(s.conf.Repo) == 0 {
+		return errors.New("ERROR: HAVE NOT set repo ")
+	}
+	info, err := s.repoInfo(s.conf)
+	if err != nil {
+		return
+	}
+	// 显示的字段和 csv 表头随 repo 和 showfields 变化，每次查询重新计算
+	arg.fields = nil
+	arg.csvHeader = false
+	return runQuery(s.client, s.conf, info, query, arg)
+}
+
+// ForgetRepo 清除 repo 的缓存，下次使用时重新获取 schema 和 repo 列表
+func (s *Session) ForgetRepo() {
+	s.repos = nil
+	s.repoInfos = map[string]*logdb.GetRepoOutput{}
+}
+
+// Results 分页加载的查询结果，用于浏览：首次查询一页，需要时再通过 scroll 加载后续的页
+type Results struct {
+	Total    int
+	Partial  int // 部分分片查询失败 (PartialSuccess) 的页数
+	Query    string
+	Records  []map[string]interface{}
+	Schema   []logdb.RepoSchemaEntry
+	client   *logdb.LogdbAPI
+	conf     Config // 查询时的配置，之后切换 repo 不影响加载后续的页
+	scrollId string
+	more     bool
+}
+
+// Open 在当前 repo 中查询，只加载第一页
+func (s *Session) Open(query string, arg *CtlArg) (r *Results, err error) {
+	if len(s.conf.Repo) == 0 {
+		return nil, errors.New("ERROR: HAVE NOT set repo ")
+	}
+	info, err := s.repoInfo(s.conf)
+	if err != nil {
+		return
+	}
+	_, sort, err := buildQueryStr(s.client, s.conf, info, &query, arg)
+	if err != nil {
+		return
+	}
+	logs, err := doQuery(s.client, s.conf, &query, sort, 0, arg.PreSize, true)
+	if err != nil {
+		return
+	}
+	r = &Results{Query: query, Schema: info.Schema, client: s.client, conf: *s.conf}
+	r.add(logs)
+	return
+}
+
+func (r *Results) add(logs *logdb.QueryLogOutput) {
+	r.Total = logs.Total
+	if logs.PartialSuccess {
+		r.Partial++
+	}
+	r.Records = append(r.Records, logs.Data...)
+	r.scrollId = logs.ScrollId
+	r.more = len(logs.Data) > 0 && len(r.Records) < r.Total && len(r.scrollId) > 1
+}
+
+// More 是否还有没加载的记录
+func (r *Results) More() bool {
+	return r.more
+}
+
+// Next 加载下一页
+func (r *Results) Next() error {
+	if !r.more {
+		return nil
+	}
+	logs, err := queryScroll(r.client, &r.conf, r.scrollId)
+	if err != nil {
+		return err
+	}
+	r.add(logs)
+	return nil
+}
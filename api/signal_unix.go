@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package api
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySnapshot 收到 SIGUSR1 时通知输出进度快照
+func notifySnapshot(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
+
+func stopSnapshot(c chan<- os.Signal) {
+	signal.Stop(c)
+}
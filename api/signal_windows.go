@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package api
+
+import (
+	"os"
+)
+
+// windows 没有 SIGUSR1 ，不支持输出进度快照
+func notifySnapshot(c chan<- os.Signal) {}
+
+func stopSnapshot(c chan<- os.Signal) {}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+	"github.com/qiniuts/qlogctl/query"
+)
+
+// SortKey 一个排序字段
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// SortSpec 多个排序字段，前面的优先
+type SortSpec []SortKey
+
+// ParseSort 解析排序参数 field1:asc,field2:desc ，也可写为 +field1,-field2 ；
+// 未指定方向时使用 order ，order 为空时为 asc
+func ParseSort(spec string, order string) (s SortSpec, err error) {
+	defaultDesc, err := parseOrder(order)
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			return nil, fmt.Errorf("ERROR: 排序参数中有空的字段 : %s", spec)
+		}
+		key := SortKey{Desc: defaultDesc}
+		sign := ""
+		if item[0] == '+' || item[0] == '-' {
+			sign, item = item[:1], strings.TrimSpace(item[1:])
+			key.Desc = sign == "-"
+		}
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			desc, err := parseOrder(item[i+1:])
+			if err != nil || len(strings.TrimSpace(item[i+1:])) == 0 {
+				return nil, fmt.Errorf("ERROR: 排序方向应为 asc 或 desc : %s", item)
+			}
+			if len(sign) != 0 && desc != key.Desc {
+				return nil, fmt.Errorf("ERROR: 排序方向矛盾 : %s%s", sign, item)
+			}
+			key.Desc = desc
+			item = strings.TrimSpace(item[:i])
+		}
+		if len(item) == 0 {
+			return nil, fmt.Errorf("ERROR: 排序参数中缺少字段名 : %s", spec)
+		}
+		if seen[item] {
+			return nil, fmt.Errorf("ERROR: 排序字段 %s 重复", item)
+		}
+		seen[item] = true
+		key.Field = item
+		s = append(s, key)
+	}
+	return
+}
+
+// parseOrder asc 或 desc ，不区分大小写，空为 asc
+func parseOrder(order string) (desc bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(order)) {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, fmt.Errorf("ERROR: 排序方式应为 asc 或 desc : %s", order)
+}
+
+// String 发送给 logdb 的排序参数
+func (s SortSpec) String() string {
+	items := make([]string, len(s))
+	for i, k := range s {
+		items[i] = k.Field + ":asc"
+		if k.Desc {
+			items[i] = k.Field + ":desc"
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// OnlyBy 是否只按 field 排序，及是否为升序
+func (s SortSpec) OnlyBy(field string) (asc bool, ok bool) {
+	if len(s) != 1 || len(field) == 0 || s[0].Field != field {
+		return
+	}
+	return !s[0].Desc, true
+}
+
+// Validate 检查排序字段存在且可以排序：对象不能排序，分词的字符串不能排序
+func (s SortSpec) Validate(schema []logdb.RepoSchemaEntry) error {
+	for _, k := range s {
+		e := query.LookupField(schema, k.Field)
+		if e == nil {
+			return fmt.Errorf("ERROR: 排序字段 %s 不存在", k.Field)
+		}
+		switch {
+		case len(e.Schemas) > 0 || e.ValueType == "object" || e.ValueType == "array":
+			return fmt.Errorf("ERROR: 排序字段 %s 为 %s 类型，不能排序", k.Field, e.ValueType)
+		case e.ValueType == "string" && !sortableAnalyzer(e.Analyzer):
+			return fmt.Errorf("ERROR: 排序字段 %s 为分词 (%s) 的字符串，不能排序", k.Field, e.Analyzer)
+		}
+	}
+	return nil
+}
+
+func sortableAnalyzer(analyzer string) bool {
+	a := strings.ToLower(analyzer)
+	return a == "" || a == "no" || strings.Contains(a, "keyword")
+}
+
+// Compare 按排序字段比较两条记录
+func (s SortSpec) Compare(a, b map[string]interface{}) int {
+	for _, k := range s {
+		x, _ := query.Lookup(a, k.Field)
+		y, _ := query.Lookup(b, k.Field)
+		c := compareValues(x, y)
+		if c == 0 {
+			continue
+		}
+		if k.Desc && x != nil && y != nil {
+			return -c
+		}
+		return c
+	}
+	return 0
+}
+
+// buildSort 由 --sort 或 --orderField --order 生成排序，都未指定时按时间字段排序
+func buildSort(arg *CtlArg, dateField string) (s SortSpec, err error) {
+	switch {
+	case len(arg.Sort) > 0:
+		return ParseSort(arg.Sort, arg.OrderType)
+	case len(arg.OrderField) > 0:
+		return ParseSort(arg.OrderField, arg.OrderType)
+	case len(dateField) > 0:
+		return ParseSort(dateField, arg.OrderType)
+	}
+	return nil, nil
+}
+
+// mergeSorted 将各自已按 spec 排好序的多组记录合并为一组（k 路归并），
+// 比较结果相同时，靠前的组中的记录在前
+func mergeSorted(spec SortSpec, lists ...[]map[string]interface{}) []map[string]interface{} {
+	h := &mergeHeap{spec: spec}
+	total := 0
+	for i, l := range lists {
+		total += len(l)
+		if len(l) > 0 {
+			h.items = append(h.items, mergeItem{list: i})
+		}
+	}
+	h.lists = lists
+	heap.Init(h)
+	merged := make([]map[string]interface{}, 0, total)
+	for h.Len() > 0 {
+		it := &h.items[0]
+		merged = append(merged, lists[it.list][it.pos])
+		it.pos++
+		if it.pos == len(lists[it.list]) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return merged
+}
+
+type mergeItem struct {
+	list, pos int
+}
+
+type mergeHeap struct {
+	spec  SortSpec
+	lists [][]map[string]interface{}
+	items []mergeItem
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if c := h.spec.Compare(h.lists[a.list][a.pos], h.lists[b.list][b.pos]); c != 0 {
+		return c < 0
+	}
+	return a.list < b.list
+}
+func (h *mergeHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return it
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	cases := []struct {
+		spec, order string
+		want        string
+	}{
+		{"ts", "", "ts:asc"},
+		{"ts", "desc", "ts:desc"},
+		{"ts", "DESC", "ts:desc"},
+		{"ts:desc", "asc", "ts:desc"},
+		{"+ts", "desc", "ts:asc"},
+		{"-ts", "", "ts:desc"},
+		{"-ts:desc", "", "ts:desc"},
+		{"+ts:asc", "desc", "ts:asc"},
+		{"status:desc, ts", "asc", "status:desc,ts:asc"},
+		{"-status,+ts", "", "status:desc,ts:asc"},
+		{" - status , ts:ASC ", "desc", "status:desc,ts:asc"},
+		{"req.latency:desc", "", "req.latency:desc"},
+	}
+	for _, c := range cases {
+		s, err := ParseSort(c.spec, c.order)
+		if err != nil {
+			t.Errorf("ParseSort(%q, %q): %v", c.spec, c.order, err)
+			continue
+		}
+		if got := s.String(); got != c.want {
+			t.Errorf("ParseSort(%q, %q) = %q, want %q", c.spec, c.order, got, c.want)
+		}
+	}
+}
+
+func TestParseSortError(t *testing.T) {
+	cases := []struct {
+		spec, order string
+		msg         string
+	}{
+		{"+ts:desc", "", "排序方向矛盾"},
+		{"-ts:asc", "", "排序方向矛盾"},
+		{"ts,ts:desc", "", "排序字段 ts 重复"},
+		{"-ts,+ts", "", "排序字段 ts 重复"},
+		{"ts:up", "", "排序方向应为 asc 或 desc"},
+		{"ts:", "", "排序方向应为 asc 或 desc"},
+		{"ts", "up", "排序方式应为 asc 或 desc"},
+		{"", "", "空的字段"},
+		{"ts,", "", "空的字段"},
+		{":desc", "", "缺少字段名"},
+		{"-", "", "缺少字段名"},
+	}
+	for _, c := range cases {
+		_, err := ParseSort(c.spec, c.order)
+		if err == nil {
+			t.Errorf("ParseSort(%q, %q): expected error", c.spec, c.order)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("ParseSort(%q, %q) error = %q, want contains %q", c.spec, c.order, err, c.msg)
+		}
+	}
+}
+
+func TestSortSpecCompare(t *testing.T) {
+	rec := func(status interface{}, ts string) map[string]interface{} {
+		v := map[string]interface{}{"ts": ts}
+		if status != nil {
+			v["status"] = status
+		}
+		return v
+	}
+	cases := []struct {
+		spec string
+		a, b map[string]interface{}
+		want int
+	}{
+		{"status", rec(200.0, "t1"), rec(500.0, "t1"), -1},
+		{"-status", rec(200.0, "t1"), rec(500.0, "t1"), 1},
+		{"-status,ts", rec(500.0, "t1"), rec(500.0, "t2"), -1},
+		{"-status,-ts", rec(500.0, "t1"), rec(500.0, "t2"), 1},
+		{"status", rec(500.0, "t1"), rec(500.0, "t1"), 0},
+		// 缺少字段的记录无论升序降序都在最后
+		{"status", rec(nil, "t1"), rec(500.0, "t1"), 1},
+		{"-status", rec(nil, "t1"), rec(500.0, "t1"), 1},
+		{"-status", rec(500.0, "t1"), rec(nil, "t1"), -1},
+		{"-status,ts", rec(nil, "t1"), rec(nil, "t2"), -1},
+	}
+	for _, c := range cases {
+		s, err := ParseSort(c.spec, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Compare(c.a, c.b); got != c.want {
+			t.Errorf("%s: Compare(%v, %v) = %d, want %d", c.spec, c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	rec := func(n float64, id string) map[string]interface{} {
+		return map[string]interface{}{"n": n, "id": id}
+	}
+	ids := func(data []map[string]interface{}) string {
+		var s []string
+		for _, v := range data {
+			s = append(s, v["id"].(string))
+		}
+		return strings.Join(s, " ")
+	}
+	cases := []struct {
+		spec  string
+		lists [][]map[string]interface{}
+		want  string
+	}{
+		{"n", [][]map[string]interface{}{
+			{rec(1, "a1"), rec(3, "a3"), rec(5, "a5")},
+			{rec(2, "b2"), rec(4, "b4")},
+		}, "a1 b2 a3 b4 a5"},
+		{"-n", [][]map[string]interface{}{
+			{rec(5, "a5"), rec(1, "a1")},
+			{},
+			{rec(4, "c4"), rec(2, "c2")},
+		}, "a5 c4 c2 a1"},
+		// 相同的值按组的顺序，同一组内保持原顺序
+		{"n", [][]map[string]interface{}{
+			{rec(1, "a1"), rec(2, "a2x"), rec(2, "a2y")},
+			{rec(2, "b2x"), rec(2, "b2y")},
+			{rec(1, "c1"), rec(2, "c2")},
+		}, "a1 c1 a2x a2y b2x b2y c2"},
+		{"-n", [][]map[string]interface{}{
+			{rec(2, "a2")},
+			{rec(2, "b2")},
+			{rec(2, "c2")},
+		}, "a2 b2 c2"},
+		{"n", nil, ""},
+	}
+	for _, c := range cases {
+		s, err := ParseSort(c.spec, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(mergeSorted(s, c.lists...)); got != c.want {
+			t.Errorf("%s: mergeSorted = %q, want %q", c.spec, got, c.want)
+		}
+	}
+}
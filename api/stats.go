@@ -0,0 +1,272 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+)
+
+// sketchAccuracy 分位数的相对误差
+const sketchAccuracy = 0.01
+
+var (
+	statsQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+	statsLabels    = []string{"p50", "p90", "p99", "p999"}
+)
+
+type StatsArg struct {
+	Field    string        // 统计的数值字段
+	By       string        // 按此字段的值分组，为空表示不分组
+	Interval time.Duration // 按时间字段分桶，0 表示不分桶
+}
+
+// quantileSketch 流式分位数估计，思路同 DDSketch ：
+// 按对数将数值分桶，桶的边界为 gamma 的整数次幂，只记录各桶的条数。
+// 估计的分位数与真实值的相对误差不超过 sketchAccuracy ，内存只与数值的量级范围有关。
+type quantileSketch struct {
+	gamma    float64
+	logGamma float64
+	pos      map[int]int // 正数所在的桶
+	neg      map[int]int // 负数按绝对值所在的桶
+	zero     int
+	count    int
+}
+
+func newQuantileSketch(accuracy float64) *quantileSketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &quantileSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		pos:      map[int]int{},
+		neg:      map[int]int{},
+	}
+}
+
+func (s *quantileSketch) add(v float64) {
+	s.count++
+	switch {
+	case v > 0:
+		s.pos[s.index(v)]++
+	case v < 0:
+		s.neg[s.index(-v)]++
+	default:
+		s.zero++
+	}
+}
+
+func (s *quantileSketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value 第 i 个桶 (gamma^(i-1), gamma^i] 的代表值，与桶内任意值的相对误差不超过 accuracy
+func (s *quantileSketch) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+// quantile 估计 q 分位数，q 取值 [0, 1]
+func (s *quantileSketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	rank := int(q * float64(s.count-1))
+	// 从小到大：负数按绝对值从大到小，然后是 0 ，最后是正数
+	keys := sortedKeys(s.neg)
+	for i := len(keys) - 1; i >= 0; i-- {
+		rank -= s.neg[keys[i]]
+		if rank < 0 {
+			return -s.value(keys[i])
+		}
+	}
+	rank -= s.zero
+	if rank < 0 {
+		return 0
+	}
+	keys = sortedKeys(s.pos)
+	for _, k := range keys {
+		rank -= s.pos[k]
+		if rank < 0 {
+			return s.value(k)
+		}
+	}
+	return s.value(keys[len(keys)-1])
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// numStats 数值字段的统计，均值和方差使用 Welford 算法在线计算
+type numStats struct {
+	count  int
+	min    float64
+	max    float64
+	mean   float64
+	m2     float64
+	sketch *quantileSketch
+}
+
+func newNumStats() *numStats {
+	return &numStats{
+		min:    math.Inf(1),
+		max:    math.Inf(-1),
+		sketch: newQuantileSketch(sketchAccuracy),
+	}
+}
+
+func (s *numStats) add(v float64) {
+	s.count++
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+	d := v - s.mean
+	s.mean += d / float64(s.count)
+	s.m2 += d * (v - s.mean)
+	s.sketch.add(v)
+}
+
+// stddev 总体标准差
+func (s *numStats) stddev() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.count))
+}
+
+// numValue 将记录中的字段值转为数值，不是数值时返回 false
+func numValue(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case int64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Stats 统计满足条件的日志中数值字段的条数、最值、均值、标准差和分位数，
+// 可按另一字段的值和/或时间分组
+func Stats(conf *Config, query string, arg *CtlArg, sarg *StatsArg) (err error) {
+	if len(sarg.Field) == 0 {
+		return errors.New("ERROR: 请使用 --field 指定统计的数值字段")
+	}
+	logdbClient, err := buildClient(conf)
+	if err != nil {
+		return
+	}
+	repoInfo, err := getRepoInfo(logdbClient, conf)
+	if err != nil {
+		return
+	}
+	field := getField(repoInfo.Schema, sarg.Field)
+	if field == nil {
+		return fmt.Errorf("ERROR: repo %s 中没有字段 %s", conf.Repo[0], sarg.Field)
+	}
+	if field.ValueType != "long" && field.ValueType != "float" {
+		return fmt.Errorf("ERROR: 字段 %s 的类型为 %s ，只能统计 long 或 float 类型的字段", sarg.Field, field.ValueType)
+	}
+	if len(sarg.By) > 0 && getField(repoInfo.Schema, sarg.By) == nil {
+		return fmt.Errorf("ERROR: repo %s 中没有字段 %s", conf.Repo[0], sarg.By)
+	}
+	if err = checkInRetention(repoInfo, arg); err != nil {
+		return
+	}
+	dateField, sort, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
+	if err != nil {
+		return
+	}
+	if sarg.Interval > 0 && len(dateField) == 0 {
+		return errors.New("ERROR: 按时间分桶需要时间字段，repo 没有 date 类型字段，请使用 --dateField 指定")
+	}
+
+	groups := map[string]*numStats{}
+	skipped := 0
+	_, err = scanLogs(logdbClient, conf, query, sort, arg, func(data []map[string]interface{}) {
+		for _, v := range data {
+			n, ok := numValue(v[sarg.Field])
+			if !ok {
+				skipped++
+				continue
+			}
+			var keys []string
+			if sarg.Interval > 0 {
+				t, ok := recordTime(v[dateField])
+				if !ok {
+					skipped++
+					continue
+				}
+				keys = append(keys, truncateInLocation(t, sarg.Interval).Format(DateLayout))
+			}
+			if len(sarg.By) > 0 {
+				keys = append(keys, topValue(v[sarg.By]))
+			}
+			key := strings.Join(keys, keySep)
+			s, ok := groups[key]
+			if !ok {
+				s = newNumStats()
+				groups[key] = s
+			}
+			s.add(n)
+		}
+	})
+	if err != nil {
+		return
+	}
+	if skipped > 0 {
+		log.Warnf("%d 条记录的 %s 不是数值或缺少时间字段，已忽略\n", skipped, sarg.Field)
+	}
+	showStats(groups, sarg, dateField, arg.Split)
+	return
+}
+
+func showStats(groups map[string]*numStats, sarg *StatsArg, dateField string, split string) {
+	var header []string
+	if sarg.Interval > 0 {
+		header = append(header, dateField)
+	}
+	if len(sarg.By) > 0 {
+		header = append(header, sarg.By)
+	}
+	header = append(header, "count", "min", "max", "mean", "stddev")
+	header = append(header, statsLabels...)
+	fmt.Println(strings.Join(header, split))
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := groups[k]
+		var row []string
+		if sarg.Interval > 0 || len(sarg.By) > 0 {
+			row = strings.Split(k, keySep)
+		}
+		row = append(row, strconv.Itoa(s.count), formatNum(s.min), formatNum(s.max),
+			formatNum(s.mean), formatNum(s.stddev()))
+		for _, q := range statsQuantiles {
+			// 估计值不超出实际的最值
+			v := math.Max(s.min, math.Min(s.max, s.sketch.quantile(q)))
+			row = append(row, formatNum(v))
+		}
+		fmt.Println(strings.Join(row, split))
+	}
+}
+
+func formatNum(f float64) string {
+	return strconv.FormatFloat(f, 'g', 6, 64)
+}
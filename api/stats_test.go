@@ -0,0 +1,100 @@
+package api
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTruncateInLocation(t *testing.T) {
+	defer func(loc *time.Location) { location = loc }(location)
+	location = time.FixedZone("CST", 8*3600)
+	cases := []struct {
+		t        time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		// UTC 03:00 为东八区 11:00
+		{time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC), 24 * time.Hour, time.Date(2024, 1, 1, 0, 0, 0, 0, location)},
+		{time.Date(2023, 12, 31, 20, 0, 0, 0, time.UTC), 24 * time.Hour, time.Date(2024, 1, 1, 0, 0, 0, 0, location)},
+		{time.Date(2024, 1, 1, 3, 25, 0, 0, time.UTC), 6 * time.Hour, time.Date(2024, 1, 1, 6, 0, 0, 0, location)},
+		{time.Date(2024, 1, 1, 3, 25, 30, 0, time.UTC), 15 * time.Minute, time.Date(2024, 1, 1, 11, 15, 0, 0, location)},
+	}
+	for _, c := range cases {
+		got := truncateInLocation(c.t, c.interval)
+		if !got.Equal(c.want) {
+			t.Errorf("truncateInLocation(%v, %v) = %v, want %v", c.t, c.interval, got, c.want)
+		}
+		if got.Format(DateLayout) != c.want.Format(DateLayout) {
+			t.Errorf("truncateInLocation(%v, %v) formats as %s, want %s", c.t, c.interval, got.Format(DateLayout), c.want.Format(DateLayout))
+		}
+	}
+}
+
+func TestQuantileSketch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cases := []struct {
+		name string
+		gen  func() float64
+	}{
+		{"lognormal", func() float64 { return math.Exp(r.NormFloat64()*2 + 3) }},
+		{"uniform", func() float64 { return r.Float64() * 1000 }},
+		{"mixed sign", func() float64 { return r.NormFloat64() * 100 }},
+		{"integers with zero", func() float64 { return float64(r.Intn(20)) }},
+	}
+	quantiles := []float64{0, 0.01, 0.25, 0.5, 0.9, 0.99, 0.999, 1}
+	for _, c := range cases {
+		s := newQuantileSketch(sketchAccuracy)
+		values := make([]float64, 20000)
+		for i := range values {
+			values[i] = c.gen()
+			s.add(values[i])
+		}
+		sort.Float64s(values)
+		for _, q := range quantiles {
+			exact := values[int(q*float64(len(values)-1))]
+			got := s.quantile(q)
+			if math.Abs(got-exact) > sketchAccuracy*math.Abs(exact)+1e-9 {
+				t.Errorf("%s: quantile(%v) = %v, exact %v, relative error %v > %v",
+					c.name, q, got, exact, math.Abs(got-exact)/math.Abs(exact), sketchAccuracy)
+			}
+		}
+	}
+	if q := newQuantileSketch(sketchAccuracy).quantile(0.5); !math.IsNaN(q) {
+		t.Errorf("quantile of empty sketch = %v, want NaN", q)
+	}
+}
+
+func TestNumStats(t *testing.T) {
+	cases := []struct {
+		values       []float64
+		mean, stddev float64
+		min, max     float64
+	}{
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2, 2, 9},
+		{[]float64{-3, 3}, 0, 3, -3, 3},
+		{[]float64{42}, 42, 0, 42, 42},
+		// 数值很大、方差很小时，直接计算平方和会损失精度
+		{[]float64{1e9 + 4, 1e9 + 7, 1e9 + 13, 1e9 + 16}, 1e9 + 10, math.Sqrt(22.5), 1e9 + 4, 1e9 + 16},
+	}
+	for _, c := range cases {
+		s := newNumStats()
+		for _, v := range c.values {
+			s.add(v)
+		}
+		if s.count != len(c.values) || s.min != c.min || s.max != c.max {
+			t.Errorf("%v: count %d min %v max %v", c.values, s.count, s.min, s.max)
+		}
+		if math.Abs(s.mean-c.mean) > 1e-9*math.Abs(c.mean)+1e-12 {
+			t.Errorf("%v: mean = %v, want %v", c.values, s.mean, c.mean)
+		}
+		if math.Abs(s.stddev()-c.stddev) > 1e-9 {
+			t.Errorf("%v: stddev = %v, want %v", c.values, s.stddev(), c.stddev)
+		}
+	}
+	if s := newNumStats(); s.stddev() != 0 {
+		t.Errorf("stddev of empty stats = %v, want 0", s.stddev())
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qiniuts/qlogctl/query"
+)
+
+var (
+	// location 解析和显示时间所用的时区，默认为本地时区
+	location = time.Local
+	// renderDates 是否按 --tz 指定的时区重新格式化 date 类型字段的值。
+	// 未指定时区时按服务端返回的原样输出
+	renderDates bool
+)
+
+// SetTimezone 设置解析和显示时间所用的时区，name 为 IANA 时区名，如 Asia/Shanghai UTC 。
+// 未带时区的 --start --end 、发送给 logdb 的时间范围、统计结果中的时间及 date 类型字段都使用该时区
+func SetTimezone(name string) error {
+	if len(name) == 0 {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("ERROR: 不能识别的时区 %s ，应为 IANA 时区名，如 Asia/Shanghai UTC : %v", name, err)
+	}
+	location = loc
+	query.SetLocation(loc)
+	renderDates = true
+	return nil
+}
+
+// Location 解析和显示时间所用的时区，由 SetTimezone 设置
+func Location() *time.Location {
+	return location
+}
+
+// FieldValue 显示的字段值，指定时区时 date 类型字段的值转换为该时区的时间
+func FieldValue(valueType string, v interface{}) interface{} {
+	if !renderDates || valueType != "date" {
+		return v
+	}
+	t, ok := recordTime(v)
+	if !ok {
+		return v
+	}
+	return formatTime(t.In(location))
+}
+
+// truncateInLocation 按 location 的当地时间向下取整到 d 的整数倍，如 24h 的桶从当地 0 点开始。
+// time.Truncate 按 UTC 对齐，东八区的 24h 桶会从 08:00 开始
+func truncateInLocation(t time.Time, d time.Duration) time.Time {
+	t = t.In(location)
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(d).Add(-shift)
+}
+
+// formatTime 按 DateLayout 格式化，有毫秒时保留毫秒
+func formatTime(t time.Time) string {
+	if t.Nanosecond() != 0 {
+		return t.Format(DateLayoutMilli)
+	}
+	return t.Format(DateLayout)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/log"
+)
+
+const keySep = "\x00"
+
+type TopArg struct {
+	Fields   []string // 统计的字段，多个字段时统计值的组合
+	N        int      // 输出前多少个
+	Capacity int      // 精确统计最多保留多少个不同的值，超过后改用 Space-Saving 近似统计
+}
+
+// topCounter 统计各值出现的次数。
+// 不同值的个数不超过 capacity 时精确计数；超过后转为 Space-Saving 算法：
+// 只保留 capacity 个计数器，新值替换计数最小的值并继承其计数，count - err 为真实次数的下界。
+type topCounter struct {
+	capacity int
+	index    map[string]*topItem
+	items    topHeap // 近似统计时使用，按 count 的最小堆
+	approx   bool
+}
+
+type topItem struct {
+	key   string
+	count int
+	err   int // 近似统计时，count 可能多计的上限
+	pos   int
+}
+
+func newTopCounter(capacity int) *topCounter {
+	return &topCounter{capacity: capacity, index: map[string]*topItem{}}
+}
+
+func (c *topCounter) add(key string) {
+	if it, ok := c.index[key]; ok {
+		it.count++
+		if c.approx {
+			heap.Fix(&c.items, it.pos)
+		}
+		return
+	}
+	if len(c.index) < c.capacity {
+		it := &topItem{key: key, count: 1}
+		c.index[key] = it
+		if c.approx {
+			heap.Push(&c.items, it)
+		}
+		return
+	}
+	if !c.approx {
+		c.approx = true
+		c.items = make(topHeap, 0, len(c.index))
+		for _, it := range c.index {
+			it.pos = len(c.items)
+			c.items = append(c.items, it)
+		}
+		heap.Init(&c.items)
+	}
+	min := c.items[0]
+	delete(c.index, min.key)
+	min.key = key
+	min.err = min.count
+	min.count++
+	c.index[key] = min
+	heap.Fix(&c.items, 0)
+}
+
+// top 按次数降序返回前 n 个，n <= 0 时返回全部
+func (c *topCounter) top(n int) []*topItem {
+	items := make([]*topItem, 0, len(c.index))
+	for _, it := range c.index {
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].count != items[j].count {
+			return items[i].count > items[j].count
+		}
+		return items[i].key < items[j].key
+	})
+	if n > 0 && len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+type topHeap []*topItem
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+func (h *topHeap) Push(x interface{}) {
+	it := x.(*topItem)
+	it.pos = len(*h)
+	*h = append(*h, it)
+}
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// Top 统计满足条件的日志中，字段值或字段值组合出现的次数，按次数降序输出
+func Top(conf *Config, query string, arg *CtlArg, targ *TopArg) (err error) {
+	if len(targ.Fields) == 0 {
+		return errors.New("ERROR: 请使用 --field 指定统计的字段")
+	}
+	if targ.Capacity < 1 {
+		targ.Capacity = 1
+	}
+	logdbClient, err := buildClient(conf)
+	if err != nil {
+		return
+	}
+	repoInfo, err := getRepoInfo(logdbClient, conf)
+	if err != nil {
+		return
+	}
+	for _, f := range targ.Fields {
+		if getField(repoInfo.Schema, f) == nil {
+			return fmt.Errorf("ERROR: repo %s 中没有字段 %s", conf.Repo[0], f)
+		}
+	}
+	if err = checkInRetention(repoInfo, arg); err != nil {
+		return
+	}
+	_, sort, err := buildQueryStr(logdbClient, conf, repoInfo, &query, arg)
+	if err != nil {
+		return
+	}
+
+	counter := newTopCounter(targ.Capacity)
+	values := make([]string, len(targ.Fields))
+	total, err := scanLogs(logdbClient, conf, query, sort, arg, func(data []map[string]interface{}) {
+		for _, v := range data {
+			for i, f := range targ.Fields {
+				values[i] = topValue(v[f])
+			}
+			counter.add(strings.Join(values, keySep))
+		}
+	})
+	if err != nil {
+		return
+	}
+	if counter.approx {
+		log.Warnf("不同的值超过 %d 个，已改用近似统计，count 为估计值，error 为可能多计的上限。可增大 --capacity 以精确统计\n", targ.Capacity)
+	}
+	showTop(counter.top(targ.N), targ.Fields, total, counter.approx, arg.Split)
+	fmt.Fprintf(os.Stderr, "top records=%d distinct=%d approx=%v\n", total, len(counter.index), counter.approx)
+	return
+}
+
+func topValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	switch t := v.(type) {
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case string:
+		return replaceNewline(&t)
+	}
+	return fmt.Sprint(v)
+}
+
+func showTop(items []*topItem, fields []string, total int, approx bool, split string) {
+	header := []string{"#", "count", "percent"}
+	if approx {
+		header = append(header, "error")
+	}
+	fmt.Println(strings.Join(append(header, fields...), split))
+	for i, it := range items {
+		pct := 0.0
+		if total > 0 {
+			pct = float64(it.count) * 100 / float64(total)
+		}
+		row := []string{fmt.Sprint(i + 1), fmt.Sprint(it.count), fmt.Sprintf("%.2f%%", pct)}
+		if approx {
+			row = append(row, fmt.Sprint(it.err))
+		}
+		fmt.Println(strings.Join(append(row, strings.Split(it.key, keySep)...), split))
+	}
+}
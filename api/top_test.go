@@ -0,0 +1,71 @@
+package api
+
+import (
+	"strconv"
+	"testing"
+)
+
+// checkTopHeap 检查堆中各项的 pos 与位置一致，且满足最小堆的性质
+func checkTopHeap(t *testing.T, c *topCounter) {
+	for i, it := range c.items {
+		if it.pos != i {
+			t.Fatalf("item %q pos = %d, at %d", it.key, it.pos, i)
+		}
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(c.items) && c.items[child].count < it.count {
+				t.Fatalf("heap broken at %d: %d < %d", child, c.items[child].count, it.count)
+			}
+		}
+	}
+	if len(c.items) != len(c.index) {
+		t.Fatalf("heap has %d items, index has %d", len(c.items), len(c.index))
+	}
+}
+
+func TestTopCounterExact(t *testing.T) {
+	c := newTopCounter(10)
+	for _, k := range []string{"a", "b", "a", "c", "a", "b"} {
+		c.add(k)
+	}
+	top := c.top(2)
+	if len(top) != 2 || top[0].key != "a" || top[0].count != 3 || top[1].key != "b" || top[1].count != 2 {
+		t.Fatalf("top = %+v %+v", *top[0], *top[1])
+	}
+	if c.approx {
+		t.Fatal("should be exact")
+	}
+}
+
+func TestTopCounterApprox(t *testing.T) {
+	c := newTopCounter(5)
+	// 热点值 hot-0..hot-2 出现次数远多于其它值，其余为只出现一次的值
+	for i := 0; i < 2000; i++ {
+		c.add("hot-" + strconv.Itoa(i%3))
+		if i%2 == 0 {
+			c.add("cold-" + strconv.Itoa(i))
+		}
+		if c.approx {
+			checkTopHeap(t, c)
+		}
+	}
+	if !c.approx {
+		t.Fatal("should switch to Space-Saving")
+	}
+	if len(c.index) != 5 {
+		t.Fatalf("len(index) = %d, want 5", len(c.index))
+	}
+	top := c.top(3)
+	seen := map[string]bool{}
+	for _, it := range top {
+		seen[it.key] = true
+		// count - err 为真实次数的下界，count 为上界
+		if real := 2000 / 3; it.count-it.err > real+1 || it.count < real {
+			t.Errorf("%s: count=%d err=%d, real about %d", it.key, it.count, it.err, real)
+		}
+	}
+	for _, k := range []string{"hot-0", "hot-1", "hot-2"} {
+		if !seen[k] {
+			t.Errorf("top 3 missing %s: %+v %+v %+v", k, *top[0], *top[1], *top[2])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// maxVerifyBuckets 核对的最大分桶数，每个桶需要一次 count 查询
+const maxVerifyBuckets = 1000
+
+// checkVerifyStep 检查 --verifyInterval 分桶数不超过 maxVerifyBuckets
+func checkVerifyStep(start, end time.Time, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	if n := end.Sub(start)/interval + 1; n > maxVerifyBuckets {
+		return fmt.Errorf("ERROR: verifyInterval %v 过小，时间范围内有 %d 个桶，最多 %d 个", interval, n, maxVerifyBuckets)
+	}
+	return nil
+}
+
+// exportTally 按时间分桶统计已输出的记录，用于导出结束后与服务端逐桶核对条数
+type exportTally struct {
+	field    string
+	start    time.Time // 第一个桶的起始时间，按秒取整
+	end      time.Time
+	interval time.Duration
+	counts   []int
+	seen     []map[uint64]bool // backfill 时，每个桶已输出的记录
+	unknown  int               // 时间字段无法解析的记录数
+}
+
+func newExportTally(field string, start, end time.Time, interval time.Duration, backfill bool) *exportTally {
+	start = start.Truncate(time.Second)
+	if interval <= 0 {
+		interval = autoInterval(start, end, 60)
+	}
+	n := int(end.Sub(start)/interval) + 1
+	t := &exportTally{
+		field:    field,
+		start:    start,
+		end:      end,
+		interval: interval,
+		counts:   make([]int, n),
+	}
+	if backfill {
+		t.seen = make([]map[uint64]bool, n)
+		for i := range t.seen {
+			t.seen[i] = map[uint64]bool{}
+		}
+	}
+	return t
+}
+
+func (t *exportTally) bucket(v map[string]interface{}) int {
+	ts, ok := recordTime(v[t.field])
+	if !ok {
+		return -1
+	}
+	i := int(ts.Sub(t.start) / t.interval)
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(t.counts) {
+		i = len(t.counts) - 1
+	}
+	return i
+}
+
+func (t *exportTally) add(data []map[string]interface{}) {
+	for _, v := range data {
+		i := t.bucket(v)
+		if i < 0 {
+			t.unknown++
+			continue
+		}
+		t.counts[i]++
+		if t.seen != nil {
+			t.seen[i][fingerprint(v)] = true
+		}
+	}
+}
+
+// bucketQuery 第 i 个桶的查询条件。query 已包含整体时间范围，最后一个桶不设上界
+func (t *exportTally) bucketQuery(query string, i int) string {
+	lo := t.start.Add(time.Duration(i) * t.interval).Format(DateLayout)
+	hi := "*"
+	if i < len(t.counts)-1 {
+		hi = t.start.Add(time.Duration(i+1) * t.interval).Format(DateLayout)
+	}
+	return "(" + query + ") AND " + t.field + ":[" + lo + " TO " + hi + "}"
+}
+
+type bucketDiff struct {
+	index    int
+	exported int
+	server   int
+}
+
+// verifyExport 逐桶查询服务端条数，与已输出的条数比较，返回不一致的桶
+func verifyExport(logdbClient *logdb.LogdbAPI, conf *Config, query string, t *exportTally) (diffs []bucketDiff, err error) {
+	queries := make([]string, len(t.counts))
+	for i := range t.counts {
+		queries[i] = t.bucketQuery(query, i)
+	}
+	server, err := countBuckets(logdbClient, conf, queries, defaultConcurrency)
+	if err != nil {
+		return
+	}
+	for i, n := range server {
+		if n != t.counts[i] {
+			diffs = append(diffs, bucketDiff{index: i, exported: t.counts[i], server: n})
+		}
+	}
+	fmt.Fprintf(os.Stderr, "verify buckets=%d interval=%s mismatched=%d unknown=%d\n",
+		len(t.counts), t.interval, len(diffs), t.unknown)
+	for _, d := range diffs {
+		lo := t.start.Add(time.Duration(d.index) * t.interval)
+		fmt.Fprintf(os.Stderr, "mismatch start=%s end=%s exported=%d server=%d\n",
+			lo.Format(DateLayout), lo.Add(t.interval).Format(DateLayout), d.exported, d.server)
+	}
+	return
+}
+
+// backfillBucket 重新拉取第 i 个桶内的全部记录，返回之前没有输出过的记录
+func backfillBucket(logdbClient *logdb.LogdbAPI, conf *Config, query string, sort string,
+	t *exportTally, i int, asc bool) (out []map[string]interface{}, err error) {
+	if t.seen == nil {
+		return nil, errors.New("ERROR: backfill 需要在导出时记录已输出的记录")
+	}
+	c := newDateCursor(t.bucketQuery(query, i), t.field, asc)
+	for {
+		q := c.pageQuery()
+		var logs *logdb.QueryLogOutput
+		logs, _, err = requery(func() (*logdb.QueryLogOutput, error) {
+			return doQuery(logdbClient, conf, &q, sort, 0, autoMaxSize, false)
+		})
+		if err != nil {
+			return
+		}
+		fresh, ok := c.advance(logs.Data)
+		if !ok {
+			return nil, errors.New("ERROR: 记录缺少可解析的时间字段 " + t.field)
+		}
+		for _, v := range fresh {
+			fp := fingerprint(v)
+			if !t.seen[i][fp] {
+				t.seen[i][fp] = true
+				t.counts[i]++
+				out = append(out, v)
+			}
+		}
+		if len(logs.Data) < autoMaxSize || len(fresh) == 0 {
+			log.Debugf("backfill bucket %d: %d records\n", i, len(out))
+			return
+		}
+	}
+}
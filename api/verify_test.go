@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckVerifyStep(t *testing.T) {
+	start := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+	day := start.Add(24 * time.Hour)
+	cases := []struct {
+		end      time.Time
+		interval time.Duration
+		ok       bool
+	}{
+		{day, 0, true},
+		{day, time.Minute, false},
+		{day, 2 * time.Minute, true},
+		{start.Add(999 * time.Second), time.Second, true},
+		{start.Add(1000 * time.Second), time.Second, false},
+	}
+	for _, c := range cases {
+		err := checkVerifyStep(start, c.end, c.interval)
+		if (err == nil) != c.ok {
+			t.Errorf("checkVerifyStep(%v, %v) = %v, want ok %v", c.end.Sub(start), c.interval, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+	"github.com/qiniuts/qlogctl/query"
+)
+
+const (
+	watchSample = 1000 // 发现 --by 字段的值时，取样的条数
+	watchLag    = 2    // 每次刷新时重新统计最近的几个桶，以包含延迟写入的日志
+)
+
+type WatchArg struct {
+	By       string        // 按此字段的值分别统计，为空表示只统计总数
+	Interval time.Duration // 每个桶的时间长度
+	Window   time.Duration // 显示最近多长时间
+	Refresh  time.Duration // 刷新间隔
+	Top      int           // 最多统计 --by 字段的多少个值
+}
+
+// rateSeries 一个值在各时间桶内的条数
+type rateSeries struct {
+	label  string
+	query  string
+	counts map[int64]int // 桶的起始时间 (unix 秒) -> 条数
+	filled time.Time     // 此时间之前的桶已统计，之后的桶每次刷新时重新统计
+}
+
+// WatchRate 持续刷新最近一段时间内满足条件的日志在各时间桶内的条数，可按字段的值分别统计
+func WatchRate(conf *Config, query string, arg *CtlArg, warg *WatchArg) (err error) {
+	if warg.Interval < time.Second {
+		return errors.New("ERROR: interval 至少为 1s")
+	}
+	if warg.Window < warg.Interval {
+		return errors.New("ERROR: window 不能小于 interval")
+	}
+	if warg.Refresh <= 0 {
+		warg.Refresh = warg.Interval
+	}
+	logdbClient, err := buildClient(conf)
+	if err != nil {
+		return
+	}
+	repoInfo, err := getRepoInfo(logdbClient, conf)
+	if err != nil {
+		return
+	}
+	dateField, _, err := getDateFieldAndSort(logdbClient, conf, repoInfo, arg)
+	if err != nil {
+		return
+	}
+	if err = checkQuery(logdbClient, conf, repoInfo, query, arg); err != nil {
+		return
+	}
+	if len(dateField) == 0 {
+		return errors.New("ERROR: watch-rate 需要时间字段，repo 没有 date 类型字段，请使用 --dateField 指定")
+	}
+	// 窗口随时间移动，不能调整开始时间，只检查一次
+	end := time.Now().In(location)
+	start := end.Add(-warg.Window)
+	window := *arg
+	window.Start, window.End, window.ClampRange = &start, &end, false
+	if err = checkInRetention(repoInfo, &window); err != nil {
+		return
+	}
+	var byField *logdb.RepoSchemaEntry
+	if len(warg.By) > 0 {
+		if byField = getField(repoInfo.Schema, warg.By); byField == nil {
+			return fmt.Errorf("ERROR: repo %s 中没有字段 %s", conf.Repo[0], warg.By)
+		}
+	}
+
+	w := &rateWatcher{
+		client:    logdbClient,
+		conf:      conf,
+		where:     query,
+		dateField: dateField,
+		byField:   byField,
+		arg:       warg,
+		total:     &rateSeries{label: "total", query: query, counts: map[int64]int{}},
+		tty:       isTerminal(os.Stdout),
+	}
+	for {
+		if err = w.refresh(time.Now().In(location)); err != nil {
+			return
+		}
+		time.Sleep(warg.Refresh)
+	}
+}
+
+type rateWatcher struct {
+	client    *logdb.LogdbAPI
+	conf      *Config
+	where     string
+	dateField string
+	byField   *logdb.RepoSchemaEntry
+	arg       *WatchArg
+	total     *rateSeries
+	values    []*rateSeries
+	tty       bool
+}
+
+func (w *rateWatcher) refresh(now time.Time) error {
+	first := now.Add(-w.arg.Window).Truncate(w.arg.Interval)
+	if w.byField != nil {
+		if err := w.discover(first, now); err != nil {
+			return err
+		}
+	}
+	for _, s := range append([]*rateSeries{w.total}, w.values...) {
+		if err := w.fill(s, first, now); err != nil {
+			return err
+		}
+	}
+	w.show(first, now)
+	return nil
+}
+
+// discover 取样最近的日志，找出 --by 字段出现最多的值，最多统计 Top 个
+func (w *rateWatcher) discover(start, end time.Time) error {
+	if len(w.values) >= w.arg.Top {
+		return nil
+	}
+	q := "(" + w.where + ") AND " + w.dateField + ":[" + start.Format(DateLayout) + " TO " + end.Format(DateLayout) + "]"
+	logs, err := doQuery(w.client, w.conf, &q, w.dateField+":desc", 0, watchSample, false)
+	if err != nil {
+		return err
+	}
+	counter := newTopCounter(watchSample)
+	for _, v := range logs.Data {
+		if value, ok := v[w.byField.Key]; ok && value != nil {
+			counter.add(topValue(value))
+		}
+	}
+	known := map[string]bool{}
+	for _, s := range w.values {
+		known[s.label] = true
+	}
+	for _, it := range counter.top(0) {
+		if len(w.values) >= w.arg.Top {
+			break
+		}
+		if known[it.key] {
+			continue
+		}
+		w.values = append(w.values, &rateSeries{
+			label:  it.key,
+			query:  "(" + w.where + ") AND " + query.Eq(w.byField.Key, it.key),
+			counts: map[int64]int{},
+		})
+	}
+	return nil
+}
+
+// fill 统计 [first, now] 内还没有统计过的桶，以及最近的 watchLag 个桶
+func (w *rateWatcher) fill(s *rateSeries, first, now time.Time) error {
+	from := first
+	if !s.filled.IsZero() {
+		if redo := s.filled.Add(-watchLag * w.arg.Interval); redo.After(from) {
+			from = redo
+		}
+	}
+	buckets := newHistBuckets(from, now, w.arg.Interval)
+	if !serverHistogram(w.client, w.conf, s.query, w.dateField, buckets) {
+		if err := countHistogram(w.client, w.conf, s.query, w.dateField, buckets, defaultConcurrency); err != nil {
+			return err
+		}
+	}
+	for _, b := range buckets {
+		s.counts[b.Start.Unix()] = b.Count
+	}
+	for k := range s.counts {
+		if k < first.Unix() {
+			delete(s.counts, k)
+		}
+	}
+	s.filled = now.Truncate(w.arg.Interval)
+	return nil
+}
+
+func (s *rateSeries) series(first, now time.Time, interval time.Duration) []int {
+	var counts []int
+	for t := first; !t.After(now); t = t.Add(interval) {
+		counts = append(counts, s.counts[t.Unix()])
+	}
+	return counts
+}
+
+func (w *rateWatcher) show(first, now time.Time) {
+	rows := []*rateSeries{w.total}
+	values := append([]*rateSeries{}, w.values...)
+	sum := func(s *rateSeries) int {
+		n := 0
+		for _, c := range s.counts {
+			n += c
+		}
+		return n
+	}
+	sort.SliceStable(values, func(i, j int) bool { return sum(values[i]) > sum(values[j]) })
+	rows = append(rows, values...)
+	if w.byField != nil {
+		// 其它值：总数减去已统计的各值
+		other := &rateSeries{label: "(other)", counts: map[int64]int{}}
+		for k, n := range w.total.counts {
+			for _, s := range w.values {
+				n -= s.counts[k]
+			}
+			if n < 0 {
+				n = 0
+			}
+			other.counts[k] = n
+		}
+		rows = append(rows, other)
+	}
+
+	if !w.tty {
+		for _, s := range rows {
+			counts := s.series(first, now, w.arg.Interval)
+			fmt.Printf("rate time=%s value=%s current=%d last=%d window=%d\n", now.Format(DateLayout),
+				strconv.Quote(s.label), counts[len(counts)-1], lastComplete(counts), sum(s))
+		}
+		return
+	}
+
+	width := 10
+	for _, s := range rows {
+		if l := len(s.label); l > width {
+			width = l
+		}
+	}
+	if width > 40 {
+		width = 40
+	}
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "qlogctl watch-rate  repo: %s  query: %s  by: %s\n", w.conf.Repo[0], w.where, w.arg.By)
+	fmt.Fprintf(&b, "window: %s  interval: %s  updated: %s\n\n", w.arg.Window, w.arg.Interval, now.Format(DateLayout))
+	fmt.Fprintf(&b, "%-*s %8s %8s %8s  %s\n", width, "value", "current", "last", "window", "trend")
+	for _, s := range rows {
+		counts := s.series(first, now, w.arg.Interval)
+		label := s.label
+		if rs := []rune(label); len(rs) > width {
+			label = string(rs[:width-1]) + "~"
+		}
+		fmt.Fprintf(&b, "%-*s %8d %8d %8d  %s\n", width, label,
+			counts[len(counts)-1], lastComplete(counts), sum(s), sparkline(counts))
+	}
+	fmt.Fprint(os.Stdout, b.String())
+}
+
+// lastComplete 最后一个完整的桶，即当前桶的前一个
+func lastComplete(counts []int) int {
+	if len(counts) < 2 {
+		return 0
+	}
+	return counts[len(counts)-2]
+}
@@ -0,0 +1,506 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qiniuts/qlogctl/api"
+	"github.com/qiniuts/qlogctl/term"
+)
+
+const (
+	maxColumnWidth = 40
+	browseHelp     = "↑↓ 选择  PgUp/PgDn 翻页  ←→ 左右滚动  Enter 详情  J/K 滚动详情  f 字段  / 查询  t 时间  r 刷新  q 退出"
+	pickerHelp     = "↑↓ 选择  空格 显示/隐藏  a 全部显示  Esc/Enter 返回"
+)
+
+// browser 全屏浏览查询结果：上方为结果表格，下方为选中记录的详情
+type browser struct {
+	scr     *term.Screen
+	session *api.Session
+	arg     *api.CtlArg
+	query   string
+	last    time.Duration // 大于 0 时，查询最近这段时间
+	res     *api.Results
+	hidden  map[string]bool // 隐藏的字段
+	sel     int             // 选中的记录
+	top     int             // 表格第一行显示的记录
+	col     int             // 表格第一列显示的字段
+	detail  bool            // 是否显示详情
+	dtop    int             // 详情第一行
+	picking bool            // 正在选择显示的字段
+	pick    int
+	status  string
+}
+
+func runBrowse(session *api.Session, arg *api.CtlArg, query string, last time.Duration) (err error) {
+	scr, err := term.NewScreen()
+	if err != nil {
+		return
+	}
+	defer scr.Close()
+	b := &browser{scr: scr, session: session, arg: arg, query: query, last: last,
+		hidden: map[string]bool{}, detail: true}
+	if arg.Fields != "*" {
+		shown := map[string]bool{}
+		for _, f := range strings.Split(arg.Fields, ",") {
+			shown[strings.TrimSpace(f)] = true
+		}
+		schema, err := session.Schema()
+		if err != nil {
+			return err
+		}
+		for _, e := range schema {
+			if !shown[e.Key] {
+				b.hidden[e.Key] = true
+			}
+		}
+	}
+	b.reload()
+	for {
+		b.draw()
+		key, err := scr.ReadKey()
+		if err != nil {
+			return err
+		}
+		if b.picking {
+			b.pickKey(key)
+			continue
+		}
+		if !b.key(key) {
+			return nil
+		}
+	}
+}
+
+// reload 重新查询，只加载第一页
+func (b *browser) reload() {
+	if b.last > 0 {
+		end := time.Now().In(api.Location())
+		start := end.Add(-b.last)
+		b.arg.Start, b.arg.End = &start, &end
+	}
+	b.status = "查询中…"
+	b.draw()
+	res, err := b.session.Open(b.query, b.arg)
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+	b.res = res
+	b.sel, b.top, b.dtop = 0, 0, 0
+	b.status = ""
+	if res.Partial > 0 {
+		b.status = "部分分片查询失败 (PartialSuccess)，结果可能不完整"
+	}
+}
+
+// key 处理按键，返回 false 表示退出
+func (b *browser) key(k term.Key) bool {
+	_, rows := b.scr.Size()
+	page := b.tableHeight(rows)
+	switch {
+	case k.Code == term.KeyCtrlC || k.Rune == 'q':
+		return false
+	case k.Code == term.KeyUp || k.Rune == 'k':
+		b.moveTo(b.sel - 1)
+	case k.Code == term.KeyDown || k.Rune == 'j':
+		b.moveTo(b.sel + 1)
+	case k.Code == term.KeyPgUp:
+		b.moveTo(b.sel - page)
+	case k.Code == term.KeyPgDn || k.Rune == ' ':
+		b.moveTo(b.sel + page)
+	case k.Code == term.KeyHome || k.Rune == 'g':
+		b.moveTo(0)
+	case k.Code == term.KeyEnd || k.Rune == 'G':
+		if b.res != nil {
+			b.moveTo(len(b.res.Records) - 1)
+		}
+	case k.Code == term.KeyLeft || k.Rune == 'h':
+		if b.col > 0 {
+			b.col--
+		}
+	case k.Code == term.KeyRight || k.Rune == 'l':
+		if b.col < len(b.columns())-1 {
+			b.col++
+		}
+	case k.Code == term.KeyEnter:
+		b.detail = !b.detail
+	case k.Rune == 'J':
+		b.dtop++
+	case k.Rune == 'K':
+		if b.dtop > 0 {
+			b.dtop--
+		}
+	case k.Rune == 'f':
+		b.picking = true
+		b.pick = 0
+	case k.Rune == '/':
+		if q, ok := b.input("查询: ", b.query); ok && strings.TrimSpace(q) != "" {
+			b.query = q
+			b.reload()
+		}
+	case k.Rune == 't':
+		if s, ok := b.input("时间 (如 30m 或 <start> ~ <end>): ", b.timeText()); ok {
+			last, start, end, err := parseTimeRange(s)
+			if err != nil {
+				b.status = err.Error()
+				break
+			}
+			b.last = last
+			if last == 0 {
+				b.arg.Start, b.arg.End = &start, &end
+			}
+			b.reload()
+		}
+	case k.Rune == 'r':
+		b.reload()
+	}
+	return true
+}
+
+// moveTo 选中第 i 条记录，接近已加载的末尾时加载下一页
+func (b *browser) moveTo(i int) {
+	if b.res == nil {
+		return
+	}
+	_, rows := b.scr.Size()
+	page := b.tableHeight(rows)
+	for i >= len(b.res.Records)-page && b.res.More() {
+		b.status = "加载中…"
+		b.draw()
+		if err := b.res.Next(); err != nil {
+			b.status = err.Error()
+			break
+		}
+		b.status = ""
+	}
+	if i >= len(b.res.Records) {
+		i = len(b.res.Records) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i != b.sel {
+		b.dtop = 0
+	}
+	b.sel = i
+}
+
+func (b *browser) pickKey(k term.Key) {
+	schema, _ := b.session.Schema()
+	switch {
+	case k.Code == term.KeyEsc || k.Code == term.KeyEnter || k.Rune == 'f' || k.Rune == 'q':
+		b.picking = false
+	case k.Code == term.KeyUp || k.Rune == 'k':
+		if b.pick > 0 {
+			b.pick--
+		}
+	case k.Code == term.KeyDown || k.Rune == 'j':
+		if b.pick < len(schema)-1 {
+			b.pick++
+		}
+	case k.Rune == ' ':
+		if b.pick < len(schema) {
+			key := schema[b.pick].Key
+			b.hidden[key] = !b.hidden[key]
+		}
+	case k.Rune == 'a':
+		b.hidden = map[string]bool{}
+	}
+	if b.col >= len(b.columns()) {
+		b.col = 0
+	}
+}
+
+// input 在最后一行编辑文本，Enter 确认，Esc 取消
+func (b *browser) input(prompt string, text string) (string, bool) {
+	rs := []rune(text)
+	for {
+		b.status = prompt + string(rs) + "█"
+		b.draw()
+		k, err := b.scr.ReadKey()
+		if err != nil {
+			b.status = ""
+			return "", false
+		}
+		switch {
+		case k.Code == term.KeyEnter:
+			b.status = ""
+			return string(rs), true
+		case k.Code == term.KeyEsc || k.Code == term.KeyCtrlC:
+			b.status = ""
+			return "", false
+		case k.Code == term.KeyBackspace:
+			if len(rs) > 0 {
+				rs = rs[:len(rs)-1]
+			}
+		case k.Code == 0 && k.Rune >= ' ':
+			rs = append(rs, k.Rune)
+		}
+	}
+}
+
+func (b *browser) timeText() string {
+	if b.last > 0 {
+		return b.last.String()
+	}
+	return b.arg.Start.Format(api.DateLayout) + " ~ " + b.arg.End.Format(api.DateLayout)
+}
+
+// columns 表格中显示的字段
+func (b *browser) columns() []string {
+	schema, _ := b.session.Schema()
+	var cols []string
+	for _, e := range schema {
+		if !b.hidden[e.Key] {
+			cols = append(cols, e.Key)
+		}
+	}
+	return cols
+}
+
+func (b *browser) tableHeight(rows int) int {
+	h := rows - 3 // 标题、表头、状态栏
+	if b.detail {
+		h = (h - 1) / 2
+	}
+	if h < 1 {
+		h = 1
+	}
+	return h
+}
+
+func (b *browser) draw() {
+	cols, rows := b.scr.Size()
+	var lines []string
+	title := " " + b.session.Repo() + " | " + b.query + " | " + b.timeText()
+	if b.res != nil {
+		title += fmt.Sprintf(" | %d/%d", len(b.res.Records), b.res.Total)
+	}
+	lines = append(lines, term.Reverse(term.Fit(title, cols)))
+
+	height := b.tableHeight(rows)
+	if b.picking {
+		lines = append(lines, b.drawPicker(cols, rows-2)...)
+	} else {
+		lines = append(lines, b.drawTable(cols, height)...)
+		for len(lines) < height+2 {
+			lines = append(lines, "")
+		}
+		if b.detail {
+			lines = append(lines, strings.Repeat("─", cols))
+			lines = append(lines, b.drawDetail(cols, rows-3-height-1)...)
+		}
+	}
+	for len(lines) < rows-1 {
+		lines = append(lines, "")
+	}
+	lines = lines[:rows-1]
+	status := b.status
+	if status == "" {
+		status = browseHelp
+		if b.picking {
+			status = pickerHelp
+		}
+	}
+	lines = append(lines, term.Reverse(term.Fit(status, cols)))
+	b.scr.Draw(lines)
+}
+
+func (b *browser) drawTable(cols int, height int) []string {
+	if b.sel < b.top {
+		b.top = b.sel
+	}
+	if b.sel >= b.top+height {
+		b.top = b.sel - height + 1
+	}
+	fields := b.columns()
+	if b.col < len(fields) {
+		fields = fields[b.col:]
+	}
+	var records []map[string]interface{}
+	if b.res != nil && b.top < len(b.res.Records) {
+		records = b.res.Records[b.top:]
+		if len(records) > height {
+			records = records[:height]
+		}
+	}
+	// 列宽为表头和当前显示的值的最大宽度
+	widths := make([]int, len(fields))
+	for i, f := range fields {
+		widths[i] = term.Width(f)
+		for _, v := range records {
+			if w := term.Width(cellValue(v[f], b.valueType(f))); w > widths[i] {
+				widths[i] = w
+			}
+		}
+		if widths[i] > maxColumnWidth {
+			widths[i] = maxColumnWidth
+		}
+	}
+	row := func(values []string) string {
+		var line []string
+		used := 0
+		for i, v := range values {
+			if used >= cols {
+				break
+			}
+			line = append(line, term.Fit(v, widths[i]))
+			used += widths[i] + 1
+		}
+		return term.Fit(strings.Join(line, " "), cols)
+	}
+	lines := []string{"\x1b[1m" + row(fields) + "\x1b[0m"}
+	for i, v := range records {
+		values := make([]string, len(fields))
+		for j, f := range fields {
+			values[j] = cellValue(v[f], b.valueType(f))
+		}
+		line := row(values)
+		if b.top+i == b.sel {
+			line = term.Reverse(line)
+		}
+		lines = append(lines, line)
+	}
+	if b.res != nil && len(b.res.Records) == 0 && b.status == "" {
+		lines = append(lines, "没有满足条件的日志")
+	}
+	return lines
+}
+
+// drawDetail 选中记录的全部字段，嵌套的 json 格式化显示
+func (b *browser) drawDetail(cols int, height int) []string {
+	if b.res == nil || b.sel >= len(b.res.Records) || height <= 0 {
+		return nil
+	}
+	v := b.res.Records[b.sel]
+	var keys []string
+	seen := map[string]bool{}
+	for _, e := range b.res.Schema {
+		keys = append(keys, e.Key)
+		seen[e.Key] = true
+	}
+	var extra []string
+	for k := range v {
+		if !seen[k] {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	keys = append(keys, extra...)
+
+	width := 0
+	for _, k := range keys {
+		if w := term.Width(k); w > width {
+			width = w
+		}
+	}
+	var lines []string
+	for _, k := range keys {
+		value, ok := v[k]
+		if !ok {
+			continue
+		}
+		for i, l := range strings.Split(detailValue(value, b.valueType(k)), "\n") {
+			name := ""
+			if i == 0 {
+				name = k
+			}
+			lines = append(lines, "\x1b[36m"+term.Fit(name, width)+"\x1b[0m  "+term.Fit(l, cols-width-2))
+		}
+	}
+	if b.dtop >= len(lines) {
+		b.dtop = len(lines) - 1
+	}
+	if b.dtop > 0 {
+		lines = lines[b.dtop:]
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+func (b *browser) drawPicker(cols int, height int) []string {
+	schema, _ := b.session.Schema()
+	lines := []string{"\x1b[1m" + term.Fit("显示的字段", cols) + "\x1b[0m"}
+	start := 0
+	if b.pick >= height-1 {
+		start = b.pick - height + 2
+	}
+	for i := start; i < len(schema) && len(lines) < height; i++ {
+		mark := "[x]"
+		if b.hidden[schema[i].Key] {
+			mark = "[ ]"
+		}
+		line := term.Fit(fmt.Sprintf("%s %s  %s", mark, schema[i].Key, schema[i].ValueType), cols)
+		if i == b.pick {
+			line = term.Reverse(line)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (b *browser) valueType(field string) string {
+	if b.res == nil {
+		return ""
+	}
+	for _, e := range b.res.Schema {
+		if e.Key == field {
+			return e.ValueType
+		}
+	}
+	return ""
+}
+
+// cellValue 表格中显示的值，换行和制表符替换为空格
+func cellValue(v interface{}, valueType string) string {
+	var s string
+	switch t := api.FieldValue(valueType, v).(type) {
+	case nil:
+		return ""
+	case float64:
+		if valueType == "long" {
+			s = fmt.Sprintf("%.0f", t)
+		} else {
+			s = fmt.Sprint(t)
+		}
+	case string:
+		s = t
+	default:
+		data, err := json.Marshal(t)
+		if err != nil {
+			s = fmt.Sprint(t)
+		} else {
+			s = string(data)
+		}
+	}
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ").Replace(s)
+}
+
+// detailValue 详情中显示的值：对象、数组及内容为 json 的字符串格式化为多行
+func detailValue(v interface{}, valueType string) string {
+	switch t := v.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err == nil {
+			return string(data)
+		}
+	case string:
+		s := strings.TrimSpace(t)
+		if strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+			var buf bytes.Buffer
+			if json.Indent(&buf, []byte(s), "", "  ") == nil {
+				return buf.String()
+			}
+		}
+		return strings.NewReplacer("\r\n", "\n", "\r", "\n", "\t", "    ").Replace(t)
+	}
+	return cellValue(v, valueType)
+}
@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/qiniu/log"
 	"github.com/qiniuts/qlogctl/api"
+	lucene "github.com/qiniuts/qlogctl/query"
 	"gopkg.in/urfave/cli.v2"
 )
 
@@ -16,6 +19,11 @@ var (
 		Usage: "打印部分内部信息",
 	}
 
+	tzFlag = &cli.StringFlag{
+		Name:  "tz",
+		Usage: "时区，IANA 时区名，如 Asia/Shanghai UTC 。用于解析未带时区的 --start --end ，以及显示查询的时间范围和 date 类型字段的值。优先级高于配置文件的 timezone ，默认为本地时区",
+	}
+
 	configFlag = &cli.StringFlag{
 		Name:    "config",
 		Aliases: []string{"c"},
@@ -50,12 +58,12 @@ var (
 	orderTypeFlag = &cli.StringFlag{
 		Name:  "order",
 		Value: "desc",
-		Usage: "排序方式。若不是升序 asc 则设置为 降序 desc 。按指定的字段排序",
+		Usage: "排序方式，升序 asc 或降序 desc 。作用于 --orderField 及 --sort 中未指定方向的字段",
 	}
 
 	sortFlag = &cli.StringFlag{
 		Name:  "sort",
-		Usage: "排序，field1:asc,field2:desc, …。field 是实际字段名，asc代表升序，desc 代表降序。用逗号进行分隔。若设置此参数，则忽略“orderField”“order”参数",
+		Usage: "排序，field1:asc,field2:desc, …。field 是实际字段名，asc代表升序，desc 代表降序，也可写为 +field1,-field2 ，未指定方向时使用 --order 。用逗号进行分隔。字段需存在且可排序（不能为对象或分词的字符串）。若设置此参数，则忽略“orderField”参数",
 	}
 
 	showfieldsFlag = &cli.StringFlag{
@@ -75,9 +83,162 @@ var (
 		Usage: "显示字段分隔符",
 	}
 
-	configFlags  = []cli.Flag{debugFlag, configFlag, akFlag, skFlag, repoFlag}
-	queryFlags   = []cli.Flag{dateFieldFlag, sortFlag, orderFieldFlag, orderTypeFlag}
-	showLogFlags = []cli.Flag{showfieldsFlag, noIndexFlag, splitFlag}
+	formatFlag = &cli.StringFlag{
+		Name:  "format",
+		Value: "text",
+		Usage: "输出格式: text json(每行一条) csv(含表头)。json csv 时忽略 --noIndex --split",
+	}
+
+	colorFlag = &cli.StringFlag{
+		Name:  "color",
+		Value: "auto",
+		Usage: "是否使用颜色: auto(stdout 为终端时使用) always never 。text 格式输出到终端时，高亮字段值中与查询条件匹配的词",
+	}
+
+	whereFlag = &cli.StringFlag{
+		Name:    "where",
+		Aliases: []string{"w"},
+		Usage:   "查询条件。建议将内容写在单引号内。若不指定此参数，则使用 非指令标记 的所有内容作为查询条件",
+	}
+
+	// builderFlags 由字段和值生成查询条件，自动转义，与 --where 以 AND 连接
+	builderFlags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "eq",
+			Usage: "字段等于某个值，field=value ，可指定多次。值按原样匹配，不需要转义",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ne",
+			Usage: "字段不等于某个值，field=value ，可指定多次",
+		},
+		&cli.StringSliceFlag{
+			Name:  "in",
+			Usage: "字段等于多个值中的任意一个，field=a,b,c ，可指定多次",
+		},
+		&cli.StringSliceFlag{
+			Name:  "prefix",
+			Usage: "字段以某个值开头，field=value ，可指定多次",
+		},
+		&cli.StringSliceFlag{
+			Name:  "range",
+			Usage: "字段在范围内（包含上下界），field=lo..hi ，省略 lo 或 hi 表示不限，如 latency=100.. ，可指定多次。不含 = 时为 ISO-8601 时长，如 PT30M P1D ，表示从 --start 往后或从 --end（默认现在）往前的时间范围",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exists",
+			Usage: "存在某个字段，可指定多次",
+		},
+	}
+
+	noCheckFlag = &cli.BoolFlag{
+		Name:  "noCheck",
+		Usage: "不在本地检查查询语句的语法、字段是否存在及值的类型，直接发送给服务端",
+	}
+
+	// contextFlags 输出匹配记录前后的记录，类似 grep -A -B -C
+	contextFlags = []cli.Flag{
+		&cli.IntFlag{
+			Name:    "after-context",
+			Aliases: []string{"A"},
+			Usage:   "输出每条匹配的记录在时间字段上之后的 N 条记录，各组之间以 -- 分隔",
+		},
+		&cli.IntFlag{
+			Name:    "before-context",
+			Aliases: []string{"B"},
+			Usage:   "输出每条匹配的记录在时间字段上之前的 N 条记录",
+		},
+		&cli.IntFlag{
+			Name:    "context",
+			Aliases: []string{"C"},
+			Usage:   "同时指定 -A 和 -B ，单独指定的 -A 或 -B 优先",
+		},
+		&cli.StringFlag{
+			Name:  "context-key",
+			Usage: "前后的记录只在该字段的值与匹配记录相同的记录中查找，如 host",
+		},
+	}
+
+	strictRangeFlag = &cli.BoolFlag{
+		Name:  "strict-range",
+		Usage: "时间范围超出 repo 的保存时间时报错退出",
+	}
+	// rangeFlags 时间范围超出 repo 保存时间时的处理方式，默认只输出警告
+	rangeFlags = []cli.Flag{
+		strictRangeFlag,
+		&cli.BoolFlag{
+			Name:  "clamp-range",
+			Usage: "开始时间早于 repo 的保存时间时，调整为保存时间内最早的时间",
+		},
+	}
+
+	configFlags  = []cli.Flag{debugFlag, configFlag, akFlag, skFlag, repoFlag, tzFlag}
+	queryFlags   = []cli.Flag{dateFieldFlag, sortFlag, orderFieldFlag, orderTypeFlag, noCheckFlag}
+	showLogFlags = []cli.Flag{showfieldsFlag, noIndexFlag, splitFlag, formatFlag, colorFlag}
+	timeFlags    = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "start",
+			Aliases: []string{"s"},
+			Usage:   "查询日志的开始时间。如: 20060102T15:04，2017-04-06T17:40:30+0800，now-2h，now-1d/d，today，\"yesterday 14:00\"，@1712345678(秒)，1712345678000(毫秒)",
+		},
+		&cli.StringFlag{
+			Name:    "end",
+			Aliases: []string{"e"},
+			Usage:   "查询日志的终止时间，格式同 --start 。如: 2017-04-06T16:40:30+0800，now-1h，today",
+		},
+		&cli.Float64Flag{
+			Name:        "day",
+			Aliases:     []string{"d"},
+			Usage:       "从当前时间往前推指定天，即 24 小时，如 2.5。 day hour minute 可同时提供，",
+			DefaultText: "",
+		},
+		&cli.Float64Flag{
+			Name:        "hour",
+			Aliases:     []string{"H"},
+			Usage:       "从当前时间往前推指定小时，即 60 分，如 2.5",
+			DefaultText: "",
+		},
+		&cli.Float64Flag{
+			Name:        "minute",
+			Aliases:     []string{"m"},
+			Usage:       "从当前时间往前推指定分钟，如 30",
+			DefaultText: "",
+		},
+	}
+
+	// fetchFlags 多次拉取满足条件的日志时的参数，query --scroll 与 top stats 等共用
+	fetchFlags = []cli.Flag{
+		&cli.IntFlag{
+			Name:  "prefetch",
+			Value: 2,
+			Usage: "多次拉取时（query --scroll 、top 、stats 等），处理当前页的同时最多预取多少页，至少为 1",
+		},
+		&cli.IntFlag{
+			Name:  "confirmThreshold",
+			Usage: "多次拉取时，满足条件的总条数超过此值需确认是否继续；0 表示不确认。优先级高于配置文件内容",
+		},
+		&cli.BoolFlag{
+			Name:    "yes",
+			Aliases: []string{"y"},
+			Usage:   "总条数超过 confirmThreshold 时不询问，直接拉取",
+		},
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "部分分片查询失败 (PartialSuccess) 时报错退出。默认只输出警告",
+		},
+		&cli.BoolFlag{
+			Name:  "noProgress",
+			Usage: "多次拉取时不显示进度。默认在 stderr 显示进度：终端显示进度条，否则每 10 秒输出一行进度；收到 SIGUSR1 时立即输出一次",
+		},
+	}
+
+	// scanFlags 拉取全部满足条件的日志在本地聚合的指令，如 top stats
+	scanFlags = append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "preSize",
+			Aliases: []string{"l"},
+			Value:   "2000",
+			Usage:   "每次拉取的条数，最大值 10000",
+		},
+	}, fetchFlags...)
 
 	listRepo = &cli.Command{
 		Name:      "list",
@@ -106,12 +267,15 @@ var (
 		Name:    "sample",
 		Aliases: []string{"s"},
 		Usage:   "显示一条样例记录",
-		Flags:   configFlags,
+		Flags:   append(configFlags, colorFlag),
 		Action: func(c *cli.Context) (err error) {
 			conf, err := loadConfigAndMergeFlag(c, true)
 			if err != nil {
 				return
 			}
+			if err = api.SetColor(c.String("color")); err != nil {
+				return
+			}
 			err = api.QuerySample(conf)
 			return
 		},
@@ -122,59 +286,96 @@ var (
 		Aliases:   []string{"q"},
 		Usage:     "在时间范围内查询 logdb 内的日志",
 		ArgsUsage: " <query> \n 如 id:'\"abc*\"', id:abcd.jpg",
-		Flags: append(append(append(configFlags, queryFlags...),
-			&cli.StringFlag{
-				Name:    "where",
-				Aliases: []string{"w"},
-				Usage:   "查询条件。建议将内容写在单引号内。若不指定此参数，则使用 非指令标记 的所有内容作为查询条件",
-			},
+		Flags: append(append(append(append(append(configFlags, queryFlags...), timeFlags...), builderFlags...),
+			whereFlag,
 			&cli.BoolFlag{
 				Name:    "scroll",
 				Aliases: []string{"all"},
 				Usage:   "标记为 scroll 方式拉取日志。加此参数，表示获取满足条件的所有数据",
 			},
 			&cli.IntFlag{
-				Name:        "preSize",
-				Aliases:     []string{"l"},
-				Usage:       "查询数据条数，默认 100，最大值 10000；有 --scroll 标记时内部会多次拉取数据，表示“每次”拉取的条数，默认 2000 (获取满足条件的所有数据)。",
-				DefaultText: " ",
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "最多输出多少条。与 --scroll 同时使用时，拉取到指定条数后停止",
+			},
+			&cli.IntFlag{
+				Name:  "from",
+				Usage: "从第几条开始获取（偏移量，从 0 开始），用于分页查询。不能与 --scroll 同时使用",
+			},
+			&cli.IntFlag{
+				Name:  "page",
+				Usage: "获取第几页（从 1 开始），每页条数由 --preSize 指定。不能与 --from、--scroll 同时使用",
+			},
+			&cli.BoolFlag{
+				Name:  "count",
+				Usage: "只查询满足条件的总条数，并显示实际生效的时间范围",
+			},
+			&cli.BoolFlag{
+				Name:  "explain",
+				Usage: "不查询数据，只显示最终的查询语句、时间字段、排序、repo 和每次拉取条数",
+			},
+			&cli.BoolFlag{
+				Name:  "retry-partial",
+				Usage: "部分分片查询失败 (PartialSuccess) 时重新查询，直到完整返回（最多重试 5 次）。scroll 页需按时间字段排序，重新查询该页覆盖的时间段",
 			},
 			&cli.StringFlag{
-				Name:    "start",
-				Aliases: []string{"s"},
-				Usage:   "查询日志的开始时间。如: 20060102T15:04，20060102T15:04:05，2017-04-06T17:40:30+0800",
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "输出到指定文件，并在同目录生成 <文件名>.manifest.json ，记录 repo、查询语句、时间范围、排序、schema 及文件的条数、首尾时间、字节数和 sha256",
+			},
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "类似 tail -f ，持续查询时间字段从上次最后一条记录到现在的日志，按时间升序输出。首次输出从 --start 等指定的开始时间（默认 5 分钟前）到现在的日志。不能与 --end 、--output 同时使用",
+			},
+			&cli.DurationFlag{
+				Name:  "followInterval",
+				Value: 5 * time.Second,
+				Usage: "--follow 时，每次查询的间隔",
+			},
+			&cli.DurationFlag{
+				Name:  "lag",
+				Value: 10 * time.Second,
+				Usage: "--follow 时，每次查询往前多查的时间，用于获取延迟写入的日志，重复的记录会被去掉",
+			},
+			&cli.BoolFlag{
+				Name:  "verify",
+				Usage: "与 --scroll 同时使用。导出结束后，按时间分桶查询服务端条数，与已输出的条数逐桶核对，在 stderr 报告不一致的时间段",
+			},
+			&cli.BoolFlag{
+				Name:  "backfill",
+				Usage: "同 --verify ，并重新拉取不一致的时间段，补充输出缺少的记录（追加在已输出内容之后）",
+			},
+			&cli.DurationFlag{
+				Name:  "verifyInterval",
+				Usage: "--verify 的分桶间隔，如 1m ，至少 1s 且为整秒，最多 1000 个桶。默认根据时间范围自动选择，约 60 个桶",
 			},
 			&cli.StringFlag{
-				Name:    "end",
-				Aliases: []string{"e"},
-				Usage:   "查询日志的终止时间。如: 20060102T15:04，20060102T15:04:05，2017-04-06T16:40:30+0800",
-			},
-			&cli.Float64Flag{
-				Name:        "day",
-				Aliases:     []string{"d"},
-				Usage:       "从当前时间往前推指定天，即 24 小时，如 2.5。 day hour minute 可同时提供，",
-				DefaultText: "",
-			},
-			&cli.Float64Flag{
-				Name:        "hour",
-				Aliases:     []string{"H"},
-				Usage:       "从当前时间往前推指定小时，即 60 分，如 2.5",
-				DefaultText: "",
-			},
-			&cli.Float64Flag{
-				Name:        "minute",
-				Aliases:     []string{"m"},
-				Usage:       "从当前时间往前推指定分钟，如 30",
-				DefaultText: "",
+				Name:        "preSize",
+				Aliases:     []string{"l"},
+				Usage:       "查询数据条数，默认 100，最大值 10000；有 --scroll 标记时内部会多次拉取数据，表示“每次”拉取的条数，默认 2000 (获取满足条件的所有数据)。有 --scroll 标记时可设置为 auto ，根据每次查询的耗时和响应大小在 100 ~ 10000 之间自动调整，要求按时间字段排序",
+				DefaultText: " ",
+			},
+			&cli.DurationFlag{
+				Name:  "targetLatency",
+				Value: 3 * time.Second,
+				Usage: "--preSize auto 时，每次查询的目标耗时",
 			}),
-			showLogFlags...),
+			append(append(append(contextFlags, rangeFlags...), fetchFlags...), showLogFlags...)...),
 		Action: func(c *cli.Context) (err error) {
 			conf, err := loadConfigAndMergeFlag(c, true)
 			if err != nil {
 				return
 			}
 
-			arg := mergeArgFlag(c)
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			err = mergePageFlag(c, arg)
+			if err != nil {
+				return
+			}
 			start, end, err := mergeDateTimeFlag(c)
 			if err != nil {
 				return
@@ -183,12 +384,8 @@ var (
 			arg.End = end
 			conf.Gzip = arg.Scroll // 若查询大量数据，则启用压缩
 
-			query := c.String("where")
-			if strings.TrimSpace(query) == "" {
-				query = strings.Join(c.Args().Slice(), " ")
-			}
-			if strings.TrimSpace(query) == "" {
-				err = errors.New("ERROR: no query condition")
+			query, err := mergeWhereFlag(c)
+			if err != nil {
 				return
 			}
 			err = api.Query(conf, query, arg)
@@ -201,19 +398,23 @@ var (
 		Usage:     "通过 reqid 查询日志。",
 		UsageText: "查询条件为 reqid ，解析 reqid 设置时间范围: [field:] <reqidField>.若未提供查询字段 ，则查看 repo 是否有 reqid、resppreSizeer 字段",
 		// ArgsUsage: " [<field>:]<reqid> ",
-		Flags: append(append(append(configFlags, queryFlags...),
+		Flags: append(append(append(append(configFlags, queryFlags...),
 			&cli.StringFlag{
 				Name:    "where",
 				Aliases: []string{"w"},
 				Usage:   "查询条件。[field:] <reqidField>。若不指定此参数，则使用 非指令标记 的所有内容作为查询条件",
 			}),
+			append(contextFlags, rangeFlags...)...),
 			showLogFlags...),
 		Action: func(c *cli.Context) (err error) {
 			conf, err := loadConfigAndMergeFlag(c, true)
 			if err != nil {
 				return
 			}
-			arg := mergeArgFlag(c)
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
 			w := c.String("where")
 			if strings.TrimSpace(w) == "" {
 				w = c.Args().Get(0)
@@ -233,6 +434,387 @@ var (
 			return
 		},
 	}
+
+	histogram = &cli.Command{
+		Name:      "histogram",
+		Usage:     "按时间分桶统计满足条件的日志条数",
+		ArgsUsage: " <query> ",
+		Flags: append(append(append(append(append(configFlags, dateFieldFlag), timeFlags...), rangeFlags...), builderFlags...),
+			whereFlag, noCheckFlag,
+			&cli.StringFlag{
+				Name:  "interval",
+				Value: "auto",
+				Usage: "分桶间隔，如 1m、30s、1h 。auto 表示根据时间范围自动选择，约 60 个桶",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: "table",
+				Usage: "输出格式: table csv json bar(字符条形图) spark(单行迷你图)",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Value: 8,
+				Usage: "服务端不支持直方图时，改为逐桶查询条数，同时查询的桶数",
+			},
+		),
+		Action: func(c *cli.Context) (err error) {
+			conf, err := loadConfigAndMergeFlag(c, true)
+			if err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			arg.Start, arg.End, err = mergeDateTimeFlag(c)
+			if err != nil {
+				return
+			}
+			query, err := mergeWhereFlag(c)
+			if err != nil {
+				return
+			}
+			harg := &api.HistogramArg{
+				Format:      c.String("format"),
+				Concurrency: c.Int("concurrency"),
+			}
+			if interval := c.String("interval"); interval != "auto" {
+				harg.Interval, err = time.ParseDuration(interval)
+				if err != nil || harg.Interval < time.Second {
+					err = fmt.Errorf("ERROR: interval 格式不正确，至少 1s ：%s", interval)
+					return
+				}
+			}
+			err = api.Histogram(conf, query, arg, harg)
+			return
+		},
+	}
+
+	top = &cli.Command{
+		Name:      "top",
+		Usage:     "统计满足条件的日志中，字段值或多个字段值的组合出现的次数，按次数降序输出",
+		ArgsUsage: " <query> ",
+		Flags: append(append(append(append(append(append(configFlags, dateFieldFlag), timeFlags...), rangeFlags...), scanFlags...), builderFlags...),
+			whereFlag, noCheckFlag, splitFlag,
+			&cli.StringFlag{
+				Name:  "field",
+				Usage: "统计的字段，以逗号 , 分割，多个字段时统计值的组合。如 status,host",
+			},
+			&cli.IntFlag{
+				Name:    "top",
+				Aliases: []string{"n"},
+				Value:   10,
+				Usage:   "输出次数最多的前多少个，0 表示全部",
+			},
+			&cli.IntFlag{
+				Name:  "capacity",
+				Value: 100000,
+				Usage: "精确统计最多保留多少个不同的值，超过后改用 Space-Saving 算法近似统计，内存占用不再增长",
+			},
+		),
+		Action: func(c *cli.Context) (err error) {
+			conf, err := loadConfigAndMergeFlag(c, true)
+			if err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			arg.Start, arg.End, err = mergeDateTimeFlag(c)
+			if err != nil {
+				return
+			}
+			conf.Gzip = true
+			query, err := mergeWhereFlag(c)
+			if err != nil {
+				return
+			}
+			targ := &api.TopArg{
+				N:        c.Int("top"),
+				Capacity: c.Int("capacity"),
+			}
+			for _, f := range strings.Split(c.String("field"), ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					targ.Fields = append(targ.Fields, f)
+				}
+			}
+			err = api.Top(conf, query, arg, targ)
+			return
+		},
+	}
+
+	stats = &cli.Command{
+		Name:      "stats",
+		Usage:     "统计满足条件的日志中数值字段的条数、最小值、最大值、均值、标准差及 p50/p90/p99/p999 ，可按字段值和/或时间分组",
+		ArgsUsage: " <query> ",
+		Flags: append(append(append(append(append(append(configFlags, dateFieldFlag), timeFlags...), rangeFlags...), scanFlags...), builderFlags...),
+			whereFlag, noCheckFlag, splitFlag,
+			&cli.StringFlag{
+				Name:  "field",
+				Usage: "统计的字段，要求为 long 或 float 类型。如 latency",
+			},
+			&cli.StringFlag{
+				Name:  "by",
+				Usage: "按此字段的值分组统计",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "按时间字段分桶统计，如 1m 、1h",
+			},
+		),
+		Action: func(c *cli.Context) (err error) {
+			conf, err := loadConfigAndMergeFlag(c, true)
+			if err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			arg.Start, arg.End, err = mergeDateTimeFlag(c)
+			if err != nil {
+				return
+			}
+			conf.Gzip = true
+			query, err := mergeWhereFlag(c)
+			if err != nil {
+				return
+			}
+			sarg := &api.StatsArg{
+				Field:    strings.TrimSpace(c.String("field")),
+				By:       strings.TrimSpace(c.String("by")),
+				Interval: c.Duration("interval"),
+			}
+			if sarg.Interval < 0 {
+				err = errors.New("ERROR: interval 不能为负数")
+				return
+			}
+			err = api.Stats(conf, query, arg, sarg)
+			return
+		},
+	}
+
+	shell = &cli.Command{
+		Name:      "shell",
+		Usage:     "交互式查询：复用连接并缓存 schema ，支持行编辑、历史记录和 tab 补全。输入 :help 查看设置指令",
+		ArgsUsage: " ",
+		Flags: append(append(append(append(configFlags, queryFlags...), timeFlags...),
+			&cli.StringFlag{
+				Name:        "preSize",
+				Aliases:     []string{"l"},
+				Usage:       "每次查询的条数，默认 100，最大值 10000",
+				DefaultText: " ",
+			},
+			&cli.StringFlag{
+				Name:        "history",
+				Usage:       "历史记录文件，默认 $HOME/.qlogctl_history",
+				DefaultText: " ",
+			}),
+			showLogFlags...),
+		Action: func(c *cli.Context) (err error) {
+			conf, err := loadConfigAndMergeFlag(c, false)
+			if err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			arg.Start, arg.End, err = mergeDateTimeFlag(c)
+			if err != nil {
+				return
+			}
+			// 未指定 start end 时，每次查询重新计算最近的时间范围
+			var last time.Duration
+			if len(c.String("start")) == 0 && len(c.String("end")) == 0 {
+				last = arg.End.Sub(*arg.Start)
+			}
+			session, err := api.NewSession(conf)
+			if err != nil {
+				return
+			}
+			if len(conf.Repo) > 0 {
+				if err = session.SetRepo(conf.Repo[0]); err != nil {
+					return
+				}
+			}
+			histFile := c.String("history")
+			if histFile == "" {
+				histFile = defaultHistoryFile()
+			}
+			err = runShell(session, arg, last, histFile)
+			return
+		},
+	}
+
+	browse = &cli.Command{
+		Name:      "browse",
+		Usage:     "全屏浏览查询结果：可滚动的表格，按需加载后续的页，显示选中记录的全部字段，可切换显示的字段、修改查询条件和时间范围",
+		ArgsUsage: " <query> ",
+		Flags: append(append(append(append(configFlags, queryFlags...), timeFlags...), builderFlags...),
+			whereFlag, showfieldsFlag,
+			&cli.StringFlag{
+				Name:        "preSize",
+				Aliases:     []string{"l"},
+				Usage:       "每次加载的条数，默认 100，最大值 10000",
+				DefaultText: " ",
+			}),
+		Action: func(c *cli.Context) (err error) {
+			conf, err := loadConfigAndMergeFlag(c, true)
+			if err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			arg.Start, arg.End, err = mergeDateTimeFlag(c)
+			if err != nil {
+				return
+			}
+			// 未指定 start end 时，每次查询重新计算最近的时间范围
+			var last time.Duration
+			if len(c.String("start")) == 0 && len(c.String("end")) == 0 {
+				last = arg.End.Sub(*arg.Start)
+			}
+			query, err := mergeWhereFlag(c)
+			if err != nil {
+				return
+			}
+			session, err := api.NewSession(conf)
+			if err != nil {
+				return
+			}
+			if err = session.SetRepo(conf.Repo[0]); err != nil {
+				return
+			}
+			err = runBrowse(session, arg, query, last)
+			return
+		},
+	}
+
+	watchRate = &cli.Command{
+		Name:      "watch-rate",
+		Usage:     "持续刷新最近一段时间内满足条件的日志在各时间段的条数，可按字段的值分别统计，并显示趋势",
+		ArgsUsage: " <query> ",
+		Flags: append(append(append(configFlags, dateFieldFlag), builderFlags...),
+			whereFlag, noCheckFlag, strictRangeFlag,
+			&cli.StringFlag{
+				Name:  "by",
+				Usage: "按此字段的值分别统计，如 status 。统计最近的日志中出现最多的 --top 个值，其余的计入 (other)",
+			},
+			&cli.IntFlag{
+				Name:  "top",
+				Value: 8,
+				Usage: "--by 时，最多分别统计多少个值",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Value: time.Minute,
+				Usage: "每个时间段的长度",
+			},
+			&cli.DurationFlag{
+				Name:  "window",
+				Value: 30 * time.Minute,
+				Usage: "显示最近多长时间",
+			},
+			&cli.DurationFlag{
+				Name:  "refresh",
+				Value: 10 * time.Second,
+				Usage: "刷新间隔",
+			},
+		),
+		Action: func(c *cli.Context) (err error) {
+			conf, err := loadConfigAndMergeFlag(c, true)
+			if err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			query, err := mergeWhereFlag(c)
+			if err != nil {
+				return
+			}
+			warg := &api.WatchArg{
+				By:       strings.TrimSpace(c.String("by")),
+				Top:      c.Int("top"),
+				Interval: c.Duration("interval"),
+				Window:   c.Duration("window"),
+				Refresh:  c.Duration("refresh"),
+			}
+			if warg.Top < 1 {
+				warg.Top = 1
+			}
+			err = api.WatchRate(conf, query, arg, warg)
+			return
+		},
+	}
+
+	localQuery = &cli.Command{
+		Name:      "local-query",
+		Usage:     "在 query --format json --output 导出的文件（每行一条 json ，可为 .gz）中查询，不访问 logdb 。查询语法、时间范围、排序和输出格式同 query",
+		ArgsUsage: " <query> ",
+		Flags: append(append(append(append(queryFlags, timeFlags...), builderFlags...),
+			whereFlag, tzFlag,
+			&cli.StringSliceFlag{
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "要查询的文件，可指定多次；- 表示 stdin 。若有导出时生成的 manifest ，使用其中的 schema 和时间字段，否则根据记录推断",
+			},
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "最多输出多少条",
+			},
+			&cli.BoolFlag{
+				Name:  "count",
+				Usage: "只输出满足条件的条数",
+			}),
+			showLogFlags...),
+		Action: func(c *cli.Context) (err error) {
+			if err = api.SetTimezone(c.String("tz")); err != nil {
+				return
+			}
+			arg, err := mergeArgFlag(c)
+			if err != nil {
+				return
+			}
+			// 导出的文件一般是过去某段时间的，未指定时间时不限定时间范围
+			if c.IsSet("start") || c.IsSet("end") || c.IsSet("day") || c.IsSet("hour") || c.IsSet("minute") || hasTimeRange(c) {
+				arg.Start, arg.End, err = mergeDateTimeFlag(c)
+				if err != nil {
+					return
+				}
+			}
+			query, err := buildWhere(c)
+			if err != nil {
+				return
+			}
+			err = api.LocalQuery(query, arg, &api.LocalArg{
+				Inputs:    c.StringSlice("input"),
+				KeepOrder: !c.IsSet("sort") && !c.IsSet("orderField") && !c.IsSet("order"),
+			})
+			return
+		},
+	}
+
+	verifyManifest = &cli.Command{
+		Name:      "verify-manifest",
+		Usage:     "校验 query --output 生成的 manifest ，重新计算各文件的条数、字节数和 sha256",
+		ArgsUsage: " <manifest.json> ",
+		Action: func(c *cli.Context) (err error) {
+			path := c.Args().First()
+			if strings.TrimSpace(path) == "" {
+				err = errors.New("ERROR: no manifest file")
+				return
+			}
+			err = api.VerifyManifest(path)
+			return
+		},
+	}
 )
 
 func BuildApp() *cli.App {
@@ -244,6 +826,8 @@ func BuildApp() *cli.App {
 		Commands: []*cli.Command{
 			listRepo, querySample,
 			query, queryByReqid,
+			histogram, top, stats, watchRate,
+			shell, browse, localQuery, verifyManifest,
 		},
 		EnableShellCompletion: true,
 	}
@@ -276,6 +860,15 @@ func loadConfigAndMergeFlag(c *cli.Context, needRepo bool) (*api.Config, error)
 	if debug {
 		conf.Debug = debug
 	}
+	if c.IsSet("confirmThreshold") {
+		conf.ConfirmThreshold = c.Int("confirmThreshold")
+	}
+	if tz := c.String("tz"); tz != "" {
+		conf.Timezone = tz
+	}
+	if err := api.SetTimezone(conf.Timezone); err != nil {
+		return nil, err
+	}
 
 	// remove empty string
 	vsf := make([]string, 0)
@@ -306,23 +899,87 @@ func loadConfigAndMergeFlag(c *cli.Context, needRepo bool) (*api.Config, error)
 	return &conf, nil
 }
 
-func mergeArgFlag(c *cli.Context) *api.CtlArg {
+func mergeArgFlag(c *cli.Context) (*api.CtlArg, error) {
 	arg := &api.CtlArg{
-		Fields:     c.String("showfields"),
-		DateField:  c.String("dateField"),
-		Sort:       c.String("sort"),
-		OrderField: c.String("orderField"),
-		OrderType:  c.String("order"),
-		ShowIndex:  !c.Bool("noIndex"),
-		Split:      c.String("split"),
-		PreSize:    c.Int("preSize"),
-		Scroll:     c.Bool("scroll"),
+		Fields:       c.String("showfields"),
+		DateField:    c.String("dateField"),
+		Sort:         c.String("sort"),
+		OrderField:   c.String("orderField"),
+		OrderType:    c.String("order"),
+		ShowIndex:    !c.Bool("noIndex"),
+		Split:        c.String("split"),
+		Scroll:       c.Bool("scroll"),
+		Progress:     !c.Bool("noProgress"),
+		Count:        c.Bool("count"),
+		Explain:      c.Bool("explain"),
+		Limit:        c.Int("limit"),
+		Yes:          c.Bool("yes"),
+		Prefetch:     c.Int("prefetch"),
+		Target:       c.Duration("targetLatency"),
+		Strict:       c.Bool("strict"),
+		RetryPartial: c.Bool("retry-partial"),
+		Verify:       c.Bool("verify"),
+		Backfill:     c.Bool("backfill"),
+		VerifyStep:   c.Duration("verifyInterval"),
+		Output:       c.String("output"),
+		Follow:       c.Bool("follow"),
+		FollowStep:   c.Duration("followInterval"),
+		Lag:          c.Duration("lag"),
+		Format:       c.String("format"),
+		NoCheck:      c.Bool("noCheck"),
+		StrictRange:  c.Bool("strict-range"),
+		ClampRange:   c.Bool("clamp-range"),
+		Before:       c.Int("before-context"),
+		After:        c.Int("after-context"),
+		ContextKey:   c.String("context-key"),
+	}
+	if n := c.Int("context"); n > 0 {
+		if !c.IsSet("before-context") {
+			arg.Before = n
+		}
+		if !c.IsSet("after-context") {
+			arg.After = n
+		}
 	}
 	if len(arg.Fields) == 0 {
 		arg.Fields = "*"
 	}
-	if arg.OrderType != "asc" {
+	if err := api.SetColor(c.String("color")); err != nil {
+		return nil, err
+	}
+	switch arg.OrderType = strings.ToLower(strings.TrimSpace(arg.OrderType)); arg.OrderType {
+	case "":
 		arg.OrderType = "desc"
+	case "asc", "desc":
+	default:
+		return nil, fmt.Errorf("ERROR: order 应为 asc 或 desc : %s", c.String("order"))
+	}
+	if (arg.Verify || arg.Backfill) && !arg.Scroll {
+		return nil, errors.New("ERROR: --verify 、--backfill 只能与 --scroll 同时使用")
+	}
+	if arg.Follow {
+		switch {
+		case c.IsSet("end"):
+			return nil, errors.New("ERROR: --follow 持续输出到现在的日志，不能指定 --end")
+		case len(arg.Output) != 0:
+			return nil, errors.New("ERROR: --follow 不会结束，无法生成 manifest ，不能与 --output 同时使用，请重定向输出")
+		}
+	}
+	if c.IsSet("verifyInterval") && (arg.VerifyStep < time.Second || arg.VerifyStep%time.Second != 0) {
+		return nil, fmt.Errorf("ERROR: verifyInterval 格式不正确，至少 1s 且为整秒 ：%v", arg.VerifyStep)
+	}
+	preSize := strings.TrimSpace(c.String("preSize"))
+	if preSize == "auto" {
+		if !arg.Scroll {
+			return nil, errors.New("ERROR: preSize auto 只能与 scroll 同时使用")
+		}
+		arg.AutoSize = true
+	} else if preSize != "" {
+		size, err := strconv.Atoi(preSize)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: preSize 应为整数或 auto : %s", preSize)
+		}
+		arg.PreSize = size
 	}
 	if arg.PreSize < 1 {
 		if arg.Scroll {
@@ -333,29 +990,125 @@ func mergeArgFlag(c *cli.Context) *api.CtlArg {
 	} else {
 		arg.PreSize = api.MinInt(arg.PreSize, 10000)
 	}
-	return arg
+	return arg, nil
+}
+
+// mergeWhereFlag 查询条件，不能为空
+func mergeWhereFlag(c *cli.Context) (string, error) {
+	query, err := buildWhere(c)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(query) == "" {
+		return "", errors.New("ERROR: no query condition")
+	}
+	return query, nil
+}
+
+// buildWhere 查询条件，优先使用 --where ，否则使用 非指令标记 的所有内容；
+// 再与 --eq --in 等参数生成的条件以 AND 连接
+func buildWhere(c *cli.Context) (string, error) {
+	query := c.String("where")
+	if strings.TrimSpace(query) == "" {
+		query = strings.Join(c.Args().Slice(), " ")
+	}
+	clauses := []string{query}
+	for _, op := range []string{"eq", "ne", "in", "prefix", "range", "exists"} {
+		for _, spec := range c.StringSlice(op) {
+			if op == "range" && isTimeRange(spec) {
+				continue
+			}
+			clause, err := lucene.Clause(op, spec)
+			if err != nil {
+				return "", err
+			}
+			clauses = append(clauses, clause)
+		}
+	}
+	return lucene.And(clauses...), nil
+}
+
+func mergePageFlag(c *cli.Context, arg *api.CtlArg) error {
+	from := c.Int("from")
+	page := c.Int("page")
+	if from < 0 || page < 0 {
+		return errors.New("ERROR: from 和 page 不能为负数")
+	}
+	if from > 0 && page > 0 {
+		return errors.New("ERROR: from 和 page 不能同时指定")
+	}
+	if page > 0 {
+		from = (page - 1) * arg.PreSize
+	}
+	if from > 0 && arg.Scroll {
+		return errors.New("ERROR: from/page 不能与 scroll 同时使用")
+	}
+	arg.From = from
+	return nil
+}
+
+// hasTimeRange 是否以 --range 指定了 ISO-8601 时长
+func hasTimeRange(c *cli.Context) bool {
+	for _, spec := range c.StringSlice("range") {
+		if isTimeRange(spec) {
+			return true
+		}
+	}
+	return false
 }
 
 func mergeDateTimeFlag(c *cli.Context) (startDate *time.Time,
 	endDate *time.Time, err error) {
 	startDate = &time.Time{}
 	endDate = &time.Time{}
+	now := time.Now().In(api.Location())
 
 	start := c.String("start")
 	end := c.String("end")
 	if len(start) != 0 {
-		*startDate, err = normalizeDate(start)
+		*startDate, err = parseTime(start, now)
 		if err != nil {
 			return
 		}
 	}
 	if len(end) != 0 {
-		*endDate, err = normalizeDate(end)
+		*endDate, err = parseTime(end, now)
 		if err != nil {
 			return
 		}
 	}
-	if (len(start) == 0) && (len(end) == 0) {
+	// --range PT30M ：ISO-8601 时长，从 --start 往后或从 --end（默认现在）往前
+	var period *isoDuration
+	for _, spec := range c.StringSlice("range") {
+		if !isTimeRange(spec) {
+			continue
+		}
+		if period != nil {
+			err = errors.New("ERROR: 只能指定一个时长 --range")
+			return
+		}
+		d, err1 := parseISODuration(spec)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		period = &d
+	}
+	if period != nil {
+		switch {
+		case len(start) != 0 && len(end) != 0:
+			err = errors.New("ERROR: 时长 --range 不能与 --start --end 同时指定")
+			return
+		case len(start) != 0:
+			*endDate = period.addTo(*startDate, 1)
+		default:
+			if len(end) == 0 {
+				*endDate = now
+			}
+			*startDate = period.addTo(*endDate, -1)
+		}
+	}
+	if (len(start) == 0) && (len(end) == 0) && period == nil {
 		day := c.Float64("day")
 		hour := c.Float64("hour")
 		minute := c.Float64("minute")
@@ -363,8 +1116,8 @@ func mergeDateTimeFlag(c *cli.Context) (startDate *time.Time,
 		m := day*24*60 + hour*60 + minute
 		// 浮点数，不能通过 m != 0 判断
 		if m > 0.05 {
-			*startDate = time.Now().Add(-time.Duration(m) * time.Minute)
-			*endDate = time.Now()
+			*startDate = now.Add(-time.Duration(m) * time.Minute)
+			*endDate = now
 		}
 	}
 	if (*startDate).After(*endDate) {
@@ -373,7 +1126,7 @@ func mergeDateTimeFlag(c *cli.Context) (startDate *time.Time,
 		endDate = temp
 	}
 	if endDate.IsZero() {
-		*endDate = time.Now()
+		*endDate = now
 	}
 	if startDate.IsZero() {
 		*startDate = (*endDate).Add(-time.Minute * 5)
@@ -1,10 +1,166 @@
 package cmd
 
 import (
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/qiniuts/qlogctl/api"
+	lucene "github.com/qiniuts/qlogctl/query"
 )
 
 func TestLoadConfigAndMergeFlag(t *testing.T) {
 	// app := BuildApp()
 	// app.A
 }
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 4, 10, 15, 30, 45, 0, time.Local) // 周三
+	day := func(d, h, m int) time.Time {
+		return time.Date(2024, 4, d, h, m, 0, 0, time.Local)
+	}
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"20240410T08:00", day(10, 8, 0)},
+		{"2024-04-10 08:00:00", day(10, 8, 0)},
+		{"now", now},
+		{"NOW", now},
+		{"now-2h", now.Add(-2 * time.Hour)},
+		{"now-30m", now.Add(-30 * time.Minute)},
+		{"now+90s", now.Add(90 * time.Second)},
+		{"now-1d/d", day(9, 0, 0)},
+		{"now/h", day(10, 15, 0)},
+		{"now-1h-30m", now.Add(-90 * time.Minute)},
+		{"now/w", day(8, 0, 0)},
+		{"now-1M/M", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)},
+		{"now/y", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"today", day(10, 0, 0)},
+		{"today-1h", day(9, 23, 0)},
+		{"yesterday", day(9, 0, 0)},
+		{"yesterday 14:00", day(9, 14, 0)},
+		{"yesterday 14:00:30", time.Date(2024, 4, 9, 14, 0, 30, 0, time.Local)},
+		{"Tomorrow 09:15", day(11, 9, 15)},
+		{"today 08:00+30m", day(10, 8, 30)},
+		{"@1712345678", time.Unix(1712345678, 0)},
+		{"@1712345678.5", time.Unix(1712345678, 5e8)},
+		{"1712345678123", time.Unix(1712345678, 123e6)},
+	}
+	for _, c := range cases {
+		got, err := parseTime(c.in, now)
+		if err != nil {
+			t.Errorf("parseTime(%q): %v", c.in, err)
+			continue
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("parseTime(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseTimeError(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		in, msg string
+	}{
+		{"", "不能为空"},
+		{"later", "应以 now today yesterday tomorrow 开头"},
+		{"now-2x", "不支持的单位 x"},
+		{"now-h", "- 后应为数量和单位"},
+		{"now/d-1h", "在表达式末尾"},
+		{"yesterday 25:00", "时刻应为"},
+		{"1712345678", "@1712345678"},
+		{"@abc", "时间戳格式不正确"},
+	}
+	for _, c := range cases {
+		_, err := parseTime(c.in, now)
+		if err == nil {
+			t.Errorf("parseTime(%q): expected error", c.in)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("parseTime(%q) error = %q, want contains %q", c.in, err, c.msg)
+		}
+		if !strings.Contains(err.Error(), "支持的格式") && c.in != "" {
+			t.Errorf("parseTime(%q) error should list valid formats: %q", c.in, err)
+		}
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	end := time.Date(2024, 4, 10, 15, 30, 0, 0, time.Local)
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"PT30M", end.Add(-30 * time.Minute)},
+		{"pt1h30m", end.Add(-90 * time.Minute)},
+		{"PT0.5H", end.Add(-30 * time.Minute)},
+		{"PT90S", end.Add(-90 * time.Second)},
+		{"P1D", end.AddDate(0, 0, -1)},
+		{"P1W", end.AddDate(0, 0, -7)},
+		{"P1M", end.AddDate(0, -1, 0)},
+		{"P1DT12H", end.AddDate(0, 0, -1).Add(-12 * time.Hour)},
+	}
+	for _, c := range cases {
+		d, err := parseISODuration(c.in)
+		if err != nil {
+			t.Errorf("parseISODuration(%q): %v", c.in, err)
+			continue
+		}
+		if got := d.addTo(end, -1); !got.Equal(c.want) {
+			t.Errorf("parseISODuration(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+	for _, in := range []string{"P", "PT", "30M", "P1.5D", "PT30", "P1H", "PT1D", "P1DT"} {
+		if _, err := parseISODuration(in); err == nil {
+			t.Errorf("parseISODuration(%q): expected error", in)
+		}
+	}
+}
+
+func TestIsTimeRange(t *testing.T) {
+	for in, want := range map[string]bool{
+		"PT30M":        true,
+		"p1d":          true,
+		"latency=1..2": false,
+		"path=P1..P9":  false,
+		"status=500..": false,
+		"":             false,
+	} {
+		if got := isTimeRange(in); got != want {
+			t.Errorf("isTimeRange(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestParseTimeInTimezone(t *testing.T) {
+	local := time.Local
+	if err := api.SetTimezone("America/New_York"); err != nil {
+		t.Fatal(err)
+	}
+	defer api.SetTimezone("Local")
+	if time.Local != local {
+		t.Fatalf("SetTimezone should not change time.Local")
+	}
+	loc := api.Location()
+	if d, err := lucene.ParseDate("2024-04-10T08:00:00"); err != nil || !d.Equal(time.Date(2024, 4, 10, 8, 0, 0, 0, loc)) {
+		t.Errorf("query.ParseDate = %v, %v, want in %v", d, err, loc)
+	}
+	now := time.Date(2024, 4, 10, 1, 30, 0, 0, time.UTC).In(loc) // 纽约 4 月 9 日 21:30
+	for in, want := range map[string]time.Time{
+		"20240410T08:00":  time.Date(2024, 4, 10, 8, 0, 0, 0, loc),
+		"today":           time.Date(2024, 4, 9, 0, 0, 0, 0, loc),
+		"yesterday 14:00": time.Date(2024, 4, 8, 14, 0, 0, 0, loc),
+	} {
+		got, err := parseTime(in, now)
+		if err != nil {
+			t.Errorf("parseTime(%q): %v", in, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseTime(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniuts/qlogctl/api"
+	"github.com/qiniuts/qlogctl/term"
+)
+
+var shellMetaCommands = []string{
+	":help", ":repo", ":repos", ":fields", ":time", ":showfields",
+	":format", ":size", ":order", ":status", ":refresh", ":quit", ":exit",
+}
+
+const shellHelp = `输入查询条件直接查询，如 status:500 AND method:GET 。以 : 开头的为设置指令：
+  :repo [name]               显示或切换 repo
+  :repos                     列出所有 repo
+  :fields                    列出当前 repo 的字段
+  :time 30m                  查询最近 30 分钟，每次查询时重新计算，如 90s 1h 2d
+  :time <start> [~ <end>]    查询固定的时间范围，格式同 --start --end
+  :showfields <a,b|*>        显示哪些字段
+  :format text|json|csv      输出格式
+  :size <n>                  每次查询的条数，最大 10000
+  :order asc|desc            按时间字段排序的方式
+  :status                    显示当前设置
+  :refresh                   重新获取 repo 列表和字段
+  :quit                      退出，也可按 Ctrl-D
+tab 补全字段名、repo 名和设置指令；上下方向键浏览历史记录。`
+
+// shellState 交互式查询的当前设置
+type shellState struct {
+	session *api.Session
+	arg     *api.CtlArg
+	last    time.Duration // 大于 0 时，查询最近这段时间
+}
+
+func runShell(session *api.Session, arg *api.CtlArg, last time.Duration, histFile string) error {
+	st := &shellState{session: session, arg: arg, last: last}
+	editor := term.NewEditor(histFile)
+	editor.Complete = st.complete
+	fmt.Println("输入 :help 查看帮助，:quit 或 Ctrl-D 退出")
+	for {
+		line, err := editor.Readline("qlogctl:" + session.Repo() + "> ")
+		if err == term.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		editor.AddHistory(line)
+		if strings.HasPrefix(line, ":") {
+			quit, err := st.meta(line)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+		if st.last > 0 {
+			end := time.Now().In(api.Location())
+			start := end.Add(-st.last)
+			arg.Start, arg.End = &start, &end
+		}
+		if err := session.Query(line, arg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// meta 执行设置指令，返回是否退出
+func (st *shellState) meta(line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	name, rest := fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch name {
+	case ":help", ":h":
+		fmt.Println(shellHelp)
+	case ":quit", ":exit", ":q":
+		return true, nil
+	case ":repo":
+		if rest == "" {
+			fmt.Println(st.session.Repo())
+			return
+		}
+		err = st.session.SetRepo(rest)
+	case ":repos":
+		err = st.session.ShowRepos(false)
+	case ":fields":
+		schema, err1 := st.session.Schema()
+		if err1 != nil {
+			return false, err1
+		}
+		for _, e := range schema {
+			fmt.Printf("%s\t%s\n", e.Key, e.ValueType)
+		}
+	case ":time":
+		err = st.setTime(rest)
+	case ":showfields":
+		if rest == "" {
+			fmt.Println(st.arg.Fields)
+			return
+		}
+		st.arg.Fields = rest
+	case ":format":
+		switch rest {
+		case "":
+			fmt.Println(st.arg.Format)
+		case "text", "json", "csv":
+			st.arg.Format = rest
+		default:
+			err = fmt.Errorf("ERROR: 不支持的输出格式 %s ，可选 text json csv", rest)
+		}
+	case ":size":
+		size, err1 := strconv.Atoi(rest)
+		if err1 != nil || size < 1 {
+			return false, fmt.Errorf("ERROR: size 应为正整数 : %s", rest)
+		}
+		st.arg.PreSize = api.MinInt(size, 10000)
+	case ":order":
+		if rest != "asc" && rest != "desc" {
+			return false, fmt.Errorf("ERROR: order 应为 asc 或 desc : %s", rest)
+		}
+		st.arg.OrderType = rest
+		st.arg.Sort = ""
+	case ":status":
+		st.status()
+	case ":refresh":
+		st.session.ForgetRepo()
+	default:
+		err = fmt.Errorf("ERROR: 未知的指令 %s ，输入 :help 查看帮助", name)
+	}
+	return
+}
+
+func (st *shellState) setTime(s string) error {
+	last, start, end, err := parseTimeRange(s)
+	if err != nil {
+		return err
+	}
+	st.last = last
+	if last == 0 {
+		st.arg.Start, st.arg.End = &start, &end
+	}
+	return nil
+}
+
+// parseTimeRange 解析时间范围：30m 2d 等表示最近一段时间，返回 last ；
+// 否则为 <start> [~ <end>] ，未指定 end 时为当前时间
+func parseTimeRange(s string) (last time.Duration, start, end time.Time, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		err = fmt.Errorf("ERROR: 请指定时间，如 30m 或 <start> ~ <end>")
+		return
+	}
+	if d, err1 := parseShellDuration(s); err1 == nil {
+		if d <= 0 {
+			err = fmt.Errorf("ERROR: 时间应大于 0 : %s", s)
+		}
+		last = d
+		return
+	}
+	now := time.Now().In(api.Location())
+	// 整体为一个时间，如 "yesterday 14:00"
+	if t, err1 := parseTime(s, now); err1 == nil {
+		start, end = t, now
+		if start.After(end) {
+			start, end = end, start
+		}
+		return
+	}
+	var parts []string
+	if strings.Contains(s, "~") {
+		parts = strings.SplitN(s, "~", 2)
+	} else {
+		parts = strings.Fields(s)
+	}
+	if len(parts) > 2 {
+		err = fmt.Errorf("ERROR: 时间格式不正确 : %s", s)
+		return
+	}
+	start, err = parseTime(parts[0], now)
+	if err != nil {
+		return
+	}
+	end = now
+	if len(parts) == 2 {
+		end, err = parseTime(parts[1], now)
+		if err != nil {
+			return
+		}
+	}
+	if start.After(end) {
+		start, end = end, start
+	}
+	return
+}
+
+// parseShellDuration 同 time.ParseDuration ，另外支持以 d 表示天，如 2d
+func parseShellDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (st *shellState) status() {
+	fmt.Printf("repo:       %s\n", st.session.Repo())
+	if st.last > 0 {
+		fmt.Printf("time:       最近 %s\n", st.last)
+	} else {
+		fmt.Printf("time:       %s ~ %s\n", st.arg.Start.Format(api.DateLayout), st.arg.End.Format(api.DateLayout))
+	}
+	fmt.Printf("showfields: %s\n", st.arg.Fields)
+	fmt.Printf("format:     %s\n", st.arg.Format)
+	fmt.Printf("size:       %d\n", st.arg.PreSize)
+	fmt.Printf("order:      %s\n", st.arg.OrderType)
+}
+
+// complete tab 补全：设置指令名、repo 名、输出格式和字段名
+func (st *shellState) complete(line string, pos int) (head string, completions []string, tail string) {
+	rs := []rune(line)
+	before, tail := string(rs[:pos]), string(rs[pos:])
+	start := strings.LastIndexAny(before, " \t(),!+-") + 1
+	head, word := before[:start], before[start:]
+
+	var candidates []string
+	suffix := ""
+	switch {
+	case strings.HasPrefix(before, ":") && !strings.ContainsAny(before, " \t"):
+		candidates = shellMetaCommands
+	case strings.HasPrefix(before, ":repo "):
+		candidates, _ = st.session.RepoNames()
+	case strings.HasPrefix(before, ":format "):
+		candidates = []string{"text", "json", "csv"}
+	case strings.HasPrefix(before, ":showfields "):
+		candidates = st.fieldNames()
+	case strings.HasPrefix(before, ":"):
+		return
+	default:
+		// 查询条件中，只补全字段名
+		if strings.ContainsAny(word, ":\"'") {
+			return
+		}
+		candidates = st.fieldNames()
+		suffix = ":"
+	}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			completions = append(completions, c+suffix)
+		}
+	}
+	sort.Strings(completions)
+	return
+}
+
+func (st *shellState) fieldNames() []string {
+	schema, err := st.session.Schema()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(schema))
+	for i, e := range schema {
+		names[i] = e.Key
+	}
+	return names
+}
+
+// defaultHistoryFile 历史记录默认保存在 $HOME/.qlogctl_history
+func defaultHistoryFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".qlogctl_history")
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/qiniuts/qlogctl/api"
+	lucene "github.com/qiniuts/qlogctl/query"
+)
+
+// timeFormats 出错时提示支持的时间格式
+const timeFormats = `支持的格式:
+  绝对时间   20060102T15:04  20060102T15:04:05  2006-01-02T15:04:05  2006-01-02 15:04:05  2017-04-06T17:40:30+0800
+  相对时间   now  now-2h  now-30m  now-1d/d (昨天 0 点)  now/h ，单位 s m h d w M(月) y ，/ 后为向下取整的单位
+  日期       today  yesterday  tomorrow ，可带时刻如 "yesterday 14:00" ，也可接相对时间如 today-1h
+  时间戳     @1712345678 (秒)  1712345678000 (毫秒)`
+
+// parseTime 解析 --start/--end 等参数中的时间，相对时间以 now 为基准
+func parseTime(s string, now time.Time) (t time.Time, err error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		err = errors.New("ERROR: 时间不能为空\n" + timeFormats)
+		return
+	}
+	if t, err = normalizeDate(s); err == nil {
+		return
+	}
+	if strings.HasPrefix(s, "@") {
+		return parseEpoch(s[1:], time.Second, s)
+	}
+	if isDigits(s) {
+		if len(s) < 12 {
+			err = fmt.Errorf("ERROR: 时间格式不正确 : %s 。纯数字按毫秒时间戳解析，秒级时间戳请写为 @%s\n%s", s, s, timeFormats)
+			return
+		}
+		return parseEpoch(s, time.Millisecond, s)
+	}
+	return parseTimeExpr(s, now)
+}
+
+func parseEpoch(v string, unit time.Duration, s string) (t time.Time, err error) {
+	fail := fmt.Errorf("ERROR: 时间戳格式不正确 : %s\n%s", s, timeFormats)
+	parts := strings.SplitN(v, ".", 2)
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return t, fail
+	}
+	ns := n * int64(unit)
+	if len(parts) == 2 {
+		f, err1 := strconv.ParseFloat("0."+parts[1], 64)
+		if err1 != nil || !isDigits(parts[1]) {
+			return t, fail
+		}
+		ns += int64(f * float64(unit))
+	}
+	return time.Unix(0, ns).In(api.Location()), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// parseTimeExpr 解析 now today yesterday tomorrow 开头的表达式：
+// 基准[ 时刻][+-数量单位]...[/取整单位]，如 now-1d/d ，yesterday 14:00 ，today+9h
+func parseTimeExpr(s string, now time.Time) (t time.Time, err error) {
+	fail := func(reason string) (time.Time, error) {
+		return time.Time{}, fmt.Errorf("ERROR: 时间格式不正确 : %s ，%s\n%s", s, reason, timeFormats)
+	}
+	lower := strings.ToLower(s)
+	var rest string
+	switch {
+	case strings.HasPrefix(lower, "now"):
+		t, rest = now, s[3:]
+	case strings.HasPrefix(lower, "today"):
+		t, rest = lucene.TruncateTime(now, 'd'), s[5:]
+	case strings.HasPrefix(lower, "yesterday"):
+		t, rest = lucene.TruncateTime(now, 'd').AddDate(0, 0, -1), s[9:]
+	case strings.HasPrefix(lower, "tomorrow"):
+		t, rest = lucene.TruncateTime(now, 'd').AddDate(0, 0, 1), s[8:]
+	default:
+		return fail("应以 now today yesterday tomorrow 开头，或为上述绝对时间、时间戳")
+	}
+
+	// 日期后的时刻，如 yesterday 14:00
+	if strings.HasPrefix(rest, " ") && !strings.HasPrefix(lower, "now") {
+		clock := strings.TrimSpace(rest)
+		if i := strings.IndexAny(clock, "+-/"); i >= 0 {
+			clock, rest = clock[:i], clock[i:]
+		} else {
+			rest = ""
+		}
+		c, err1 := parseClock(clock)
+		if err1 != nil {
+			return fail("时刻应为 15:04 或 15:04:05")
+		}
+		t = t.Add(c)
+	}
+	rest = strings.TrimSpace(rest)
+
+	for len(rest) > 0 {
+		op := rest[0]
+		rest = rest[1:]
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		switch op {
+		case '+', '-':
+			if i == 0 || i == len(rest) {
+				return fail(fmt.Sprintf("%c 后应为数量和单位，如 %c2h", op, op))
+			}
+			n, _ := strconv.Atoi(rest[:i])
+			if op == '-' {
+				n = -n
+			}
+			if t, err = lucene.AddTime(t, n, rest[i]); err != nil {
+				return fail(err.Error())
+			}
+			rest = rest[i+1:]
+		case '/':
+			if i != 0 || len(rest) != 1 {
+				return fail("/ 后应为单个单位，且在表达式末尾，如 now/d")
+			}
+			if !strings.ContainsRune("smhdwMy", rune(rest[0])) {
+				return fail(fmt.Sprintf("不支持的单位 %c ，可选 s m h d w M y", rest[0]))
+			}
+			t = lucene.TruncateTime(t, rest[0])
+			rest = ""
+		default:
+			return fail(fmt.Sprintf("不能识别 %q", string(op)+rest))
+		}
+	}
+	return
+}
+
+func parseClock(s string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if c, err := time.Parse(layout, s); err == nil {
+			return time.Duration(c.Hour())*time.Hour + time.Duration(c.Minute())*time.Minute +
+				time.Duration(c.Second())*time.Second, nil
+		}
+	}
+	return 0, errors.New("invalid clock")
+}
+
+// isoDuration ISO-8601 时长，如 PT30M P1DT2H
+type isoDuration struct {
+	years, months, days int
+	clock               time.Duration
+}
+
+// parseISODuration 解析 P[nY][nM][nW][nD][T[nH][nM][nS]] ，时分秒可为小数
+func parseISODuration(s string) (d isoDuration, err error) {
+	fail := func() (isoDuration, error) {
+		return isoDuration{}, fmt.Errorf("ERROR: 时长格式不正确 : %s ，应为 ISO-8601 格式，如 PT30M PT1H30M P1D P1W P1DT12H", s)
+	}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	if !strings.HasPrefix(upper, "P") || len(upper) < 3 {
+		return fail()
+	}
+	inTime := false
+	num := ""
+	for _, c := range upper[1:] {
+		switch {
+		case c >= '0' && c <= '9' || c == '.' || c == ',':
+			if c == ',' {
+				c = '.'
+			}
+			num += string(c)
+			continue
+		case c == 'T':
+			if inTime || num != "" {
+				return fail()
+			}
+			inTime = true
+			continue
+		}
+		if num == "" {
+			return fail()
+		}
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return fail()
+		}
+		n := int(f)
+		if !inTime && float64(n) != f {
+			return fail()
+		}
+		switch {
+		case !inTime && c == 'Y':
+			d.years += n
+		case !inTime && c == 'M':
+			d.months += n
+		case !inTime && c == 'W':
+			d.days += 7 * n
+		case !inTime && c == 'D':
+			d.days += n
+		case inTime && c == 'H':
+			d.clock += time.Duration(f * float64(time.Hour))
+		case inTime && c == 'M':
+			d.clock += time.Duration(f * float64(time.Minute))
+		case inTime && c == 'S':
+			d.clock += time.Duration(f * float64(time.Second))
+		default:
+			return fail()
+		}
+		num = ""
+	}
+	if num != "" || strings.HasSuffix(upper, "T") {
+		return fail()
+	}
+	if d == (isoDuration{}) {
+		return fail()
+	}
+	return
+}
+
+func (d isoDuration) addTo(t time.Time, sign int) time.Time {
+	return t.AddDate(sign*d.years, sign*d.months, sign*d.days).Add(time.Duration(sign) * d.clock)
+}
+
+// isTimeRange --range 的值不含 = 且以 P 开头时为 ISO-8601 时长，表示时间范围
+func isTimeRange(spec string) bool {
+	spec = strings.TrimSpace(spec)
+	return !strings.Contains(spec, "=") && (strings.HasPrefix(spec, "P") || strings.HasPrefix(spec, "p"))
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/qiniu/log"
+	"github.com/qiniuts/qlogctl/api"
 )
 
 var (
@@ -22,7 +23,7 @@ func normalizeDate(str string) (t time.Time, err error) {
 		"20060102T15:04", "20060102T15:04:05",
 		"2006-01-02T15:04:05", "2006-01-02 15:04:05"}
 	for _, df := range dfs {
-		t, err = time.ParseInLocation(df, str, time.Local)
+		t, err = time.ParseInLocation(df, str, api.Location())
 		if err == nil {
 			return
 		}
@@ -0,0 +1,163 @@
+// Package query 解析 LogDB 的 Lucene 风格查询语句，并根据 repo 的 schema 检查字段和值的类型，
+// 在发送请求前报告错误及其所在的列。
+package query
+
+import (
+	"strings"
+)
+
+// Node 语法树的节点
+type Node interface {
+	// Pos 节点在查询语句中的起始列，从 1 开始
+	Pos() int
+	// String 重新生成的查询语句
+	String() string
+}
+
+// Term 单个词、短语、通配符或正则，如 status:500 、msg:"time out" 、host:web* 、path:/a.*b/
+type Term struct {
+	Field    string // 为空表示未指定字段
+	Value    string // 去掉引号和转义后的值
+	Phrase   bool   // 带引号的短语
+	Wildcard bool   // 包含未转义的 * 或 ?
+	Regexp   bool   // /.../ 正则
+	Fuzzy    string // 模糊匹配或短语间距，包括 ~ ，如 ~ 、~2 ；没有时为空
+	Boost    string // ^ 后的内容，如 ^2 ；没有 ^ 时为空
+	raw      string // 原始写法，保留转义
+	pos      int
+	vpos     int // 值的起始列
+}
+
+func (t *Term) Pos() int { return t.pos }
+
+func (t *Term) String() string {
+	s := t.raw
+	if t.Field != "" {
+		s = t.Field + ":" + s
+	}
+	s += t.Fuzzy
+	if t.Boost != "" {
+		s += "^" + t.Boost
+	}
+	return s
+}
+
+// Range 范围，如 latency:[100 TO 200} 。上下界为 * 表示不限
+type Range struct {
+	Field        string
+	Lo, Hi       string
+	IncLo, IncHi bool // 是否包含上下界，[ ] 包含，{ } 不包含
+	pos          int
+	loPos, hiPos int
+}
+
+func (r *Range) Pos() int { return r.pos }
+
+func (r *Range) String() string {
+	open, close := "{", "}"
+	if r.IncLo {
+		open = "["
+	}
+	if r.IncHi {
+		close = "]"
+	}
+	s := open + bound(r.Lo) + " TO " + bound(r.Hi) + close
+	if r.Field != "" {
+		s = r.Field + ":" + s
+	}
+	return s
+}
+
+func bound(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"]}") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	return s
+}
+
+// Binary 布尔运算。Op 为 AND 或 OR ；Implicit 表示两个子句之间没有运算符，由服务端的默认运算符决定
+type Binary struct {
+	Op          string
+	Implicit    bool
+	Left, Right Node
+	pos         int
+}
+
+func (b *Binary) Pos() int { return b.pos }
+
+func (b *Binary) String() string {
+	if b.Implicit {
+		return b.Left.String() + " " + b.Right.String()
+	}
+	return b.Left.String() + " " + b.Op + " " + b.Right.String()
+}
+
+// Unary 前缀运算：NOT 、! 、+（必须满足）、-（必须不满足）
+type Unary struct {
+	Op  string
+	X   Node
+	pos int
+}
+
+func (u *Unary) Pos() int { return u.pos }
+
+func (u *Unary) String() string {
+	if u.Op == "NOT" {
+		return "NOT " + u.X.String()
+	}
+	return u.Op + u.X.String()
+}
+
+// Group 括号。Field 不为空时表示 field:(a OR b) ，括号内未指定字段的词都作用于该字段
+type Group struct {
+	Field string
+	X     Node
+	Boost string
+	pos   int
+}
+
+func (g *Group) Pos() int { return g.pos }
+
+func (g *Group) String() string {
+	s := "(" + g.X.String() + ")"
+	if g.Field != "" {
+		s = g.Field + ":" + s
+	}
+	if g.Boost != "" {
+		s += "^" + g.Boost
+	}
+	return s
+}
+
+// Walk 深度优先遍历语法树，field 为节点实际作用的字段（考虑 field:(...) 的情况）
+func Walk(n Node, fn func(n Node, field string)) {
+	walk(n, "", fn)
+}
+
+func walk(n Node, field string, fn func(n Node, field string)) {
+	switch t := n.(type) {
+	case *Term:
+		if t.Field != "" {
+			field = t.Field
+		}
+		fn(n, field)
+	case *Range:
+		if t.Field != "" {
+			field = t.Field
+		}
+		fn(n, field)
+	case *Binary:
+		fn(n, field)
+		walk(t.Left, field, fn)
+		walk(t.Right, field, fn)
+	case *Unary:
+		fn(n, field)
+		walk(t.X, field, fn)
+	case *Group:
+		if t.Field != "" {
+			field = t.Field
+		}
+		fn(n, field)
+		walk(t.X, field, fn)
+	}
+}
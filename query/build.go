@@ -0,0 +1,144 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// 词中需要用 \ 转义的字符
+const specialChars = `+-&|!(){}[]^"~*?:\/`
+
+// Escape 转义词中的特殊字符和空白，使其按原样匹配
+func Escape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(specialChars, r) || unicode.IsSpace(r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Quote 将值写为短语，转义其中的 \ 和 "
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Eq field 等于 value
+func Eq(field, value string) string {
+	return Escape(field) + ":" + Quote(value)
+}
+
+// Ne field 不等于 value
+func Ne(field, value string) string {
+	return "NOT " + Eq(field, value)
+}
+
+// In field 等于 values 中的任意一个
+func In(field string, values []string) string {
+	if len(values) == 1 {
+		return Eq(field, values[0])
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = Quote(v)
+	}
+	return Escape(field) + ":(" + strings.Join(quoted, " OR ") + ")"
+}
+
+// Prefix field 以 prefix 开头
+func Prefix(field, prefix string) string {
+	return Escape(field) + ":" + Escape(prefix) + "*"
+}
+
+// Between field 在 [lo, hi] 范围内，为空表示不限
+func Between(field, lo, hi string) string {
+	if lo == "" {
+		lo = "*"
+	}
+	if hi == "" {
+		hi = "*"
+	}
+	return (&Range{Field: Escape(field), Lo: lo, Hi: hi, IncLo: true, IncHi: true}).String()
+}
+
+// Exists 存在字段 field
+func Exists(field string) string {
+	return Escape(field) + ":*"
+}
+
+// And 用 AND 连接多个查询条件，忽略空的条件。包含多个子句的条件加上括号
+func And(clauses ...string) string {
+	var parts []string
+	for _, c := range clauses {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		parts = append(parts, c)
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	for i, c := range parts {
+		if n, err := Parse(c); err != nil || needParen(n) {
+			parts[i] = "(" + c + ")"
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// needParen 与其它条件用 AND 连接时是否需要加括号
+func needParen(n Node) bool {
+	switch t := n.(type) {
+	case *Binary:
+		return true
+	case *Unary:
+		return needParen(t.X)
+	}
+	return false
+}
+
+// Clause 将 --eq --ne --in --prefix --range --exists 等参数转换为查询条件。
+// spec 为 field=value ，in 的值以逗号分隔，range 的值为 lo..hi ，exists 只有字段名
+func Clause(op, spec string) (string, error) {
+	if op == "exists" {
+		field := strings.TrimSpace(spec)
+		if field == "" {
+			return "", fmt.Errorf("ERROR: --exists 需要指定字段名")
+		}
+		return Exists(field), nil
+	}
+	i := strings.Index(spec, "=")
+	if i <= 0 {
+		return "", fmt.Errorf("ERROR: --%s 的格式应为 field=value : %s", op, spec)
+	}
+	field, value := strings.TrimSpace(spec[:i]), spec[i+1:]
+	switch op {
+	case "eq":
+		return Eq(field, value), nil
+	case "ne":
+		return Ne(field, value), nil
+	case "in":
+		return In(field, strings.Split(value, ",")), nil
+	case "prefix":
+		if value == "" {
+			return "", fmt.Errorf("ERROR: --prefix 的前缀不能为空 : %s", spec)
+		}
+		return Prefix(field, value), nil
+	case "range":
+		j := strings.Index(value, "..")
+		if j < 0 {
+			return "", fmt.Errorf("ERROR: --range 的格式应为 field=lo..hi ，lo hi 可省略其一 : %s", spec)
+		}
+		lo, hi := strings.TrimSpace(value[:j]), strings.TrimSpace(value[j+2:])
+		if lo == "" && hi == "" {
+			return "", fmt.Errorf("ERROR: --range 至少指定上界或下界 : %s", spec)
+		}
+		return Between(field, lo, hi), nil
+	}
+	return "", fmt.Errorf("ERROR: 不支持的条件 %s", op)
+}
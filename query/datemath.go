@@ -0,0 +1,93 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateMath 解析与服务端相同的相对时间：now[+-数量单位]...[/取整单位]，如 now-1h now-1d/d now/M ，
+// 单位为 s m h(H) d w M(月) y
+func DateMath(s string, now time.Time) (t time.Time, err error) {
+	if !strings.HasPrefix(s, "now") {
+		return t, fmt.Errorf("相对时间应以 now 开头 : %s", s)
+	}
+	t, rest := now, s[3:]
+	for len(rest) > 0 {
+		op := rest[0]
+		rest = rest[1:]
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		switch op {
+		case '+', '-':
+			if i == 0 || i == len(rest) {
+				return t, fmt.Errorf("%c 后应为数量和单位，如 now%c1h : %s", op, op, s)
+			}
+			n, _ := strconv.Atoi(rest[:i])
+			if op == '-' {
+				n = -n
+			}
+			if t, err = AddTime(t, n, rest[i]); err != nil {
+				return t, fmt.Errorf("%v : %s", err, s)
+			}
+			rest = rest[i+1:]
+		case '/':
+			if i != 0 || len(rest) == 0 || !strings.ContainsRune("smhHdwMy", rune(rest[0])) {
+				return t, fmt.Errorf("/ 后应为单位，如 now/d : %s", s)
+			}
+			t = TruncateTime(t, rest[0])
+			rest = rest[1:]
+		default:
+			return t, fmt.Errorf("不能识别 %q : %s", string(op)+rest, s)
+		}
+	}
+	return
+}
+
+// AddTime 加上 n 个单位的时间，单位为 s m h(H) d w M(月) y
+func AddTime(t time.Time, n int, unit byte) (time.Time, error) {
+	switch unit {
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), nil
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case 'd':
+		return t.AddDate(0, 0, n), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*n), nil
+	case 'M':
+		return t.AddDate(0, n, 0), nil
+	case 'y':
+		return t.AddDate(n, 0, 0), nil
+	}
+	return t, errors.New("不支持的单位 " + string(unit) + " ，可选 s m h d w M y")
+}
+
+// TruncateTime 在 t 所在的时区按单位向下取整，周从周一开始
+func TruncateTime(t time.Time, unit byte) time.Time {
+	y, mon, d := t.Date()
+	loc := t.Location()
+	switch unit {
+	case 's':
+		return time.Date(y, mon, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+	case 'm':
+		return time.Date(y, mon, d, t.Hour(), t.Minute(), 0, 0, loc)
+	case 'h', 'H':
+		return time.Date(y, mon, d, t.Hour(), 0, 0, 0, loc)
+	case 'd':
+		return time.Date(y, mon, d, 0, 0, 0, 0, loc)
+	case 'w':
+		return time.Date(y, mon, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case 'M':
+		return time.Date(y, mon, 1, 0, 0, 0, 0, loc)
+	case 'y':
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
@@ -0,0 +1,345 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Match 在本地判断一条记录是否满足查询条件，用于查询导出的文件。
+// 字符串按单词匹配（不区分大小写），短语要求单词连续出现；数值按数值比较，时间按时间比较。
+// 未指定运算符的相邻条件按 Lucene 的默认规则处理：+ 必须满足，- 必须不满足，其余至少满足一个
+func Match(n Node, record map[string]interface{}) bool {
+	if n == nil {
+		return true
+	}
+	return eval(n, "", record)
+}
+
+func eval(n Node, field string, record map[string]interface{}) bool {
+	switch t := n.(type) {
+	case *Term:
+		if t.Field != "" {
+			field = t.Field
+		}
+		if field == "_exists_" {
+			v, ok := Lookup(record, t.Value)
+			return ok && v != nil
+		}
+		return matchField(field, record, func(v interface{}) bool { return matchTerm(t, v) })
+	case *Range:
+		if t.Field != "" {
+			field = t.Field
+		}
+		return matchField(field, record, func(v interface{}) bool { return matchRange(t, v) })
+	case *Group:
+		if t.Field != "" {
+			field = t.Field
+		}
+		return eval(t.X, field, record)
+	case *Unary:
+		if t.Op == "+" {
+			return eval(t.X, field, record)
+		}
+		return !eval(t.X, field, record)
+	case *Binary:
+		if t.Implicit {
+			return evalClauses(implicitClauses(t), field, record)
+		}
+		if t.Op == "AND" {
+			return eval(t.Left, field, record) && eval(t.Right, field, record)
+		}
+		return eval(t.Left, field, record) || eval(t.Right, field, record)
+	}
+	return false
+}
+
+// implicitClauses 展开没有运算符连接的相邻子句
+func implicitClauses(b *Binary) []Node {
+	var clauses []Node
+	if l, ok := b.Left.(*Binary); ok && l.Implicit {
+		clauses = implicitClauses(l)
+	} else {
+		clauses = []Node{b.Left}
+	}
+	return append(clauses, b.Right)
+}
+
+func evalClauses(clauses []Node, field string, record map[string]interface{}) bool {
+	optional, matched := 0, false
+	for _, c := range clauses {
+		if u, ok := c.(*Unary); ok {
+			if !eval(c, field, record) {
+				return false
+			}
+			if u.Op == "+" {
+				matched = true
+			}
+			continue
+		}
+		optional++
+		if !matched && eval(c, field, record) {
+			matched = true
+		}
+	}
+	return matched || optional == 0
+}
+
+// matchField 对字段的值调用 fn 。未指定字段时检查所有字段，字段名可包含通配符
+func matchField(field string, record map[string]interface{}, fn func(v interface{}) bool) bool {
+	if field == "" || strings.ContainsAny(field, "*?") {
+		var re *regexp.Regexp
+		if field != "" {
+			re = globRegexp(field)
+		}
+		for _, kv := range flatten("", record, nil) {
+			if re != nil && !re.MatchString(kv.key) {
+				continue
+			}
+			if fn(kv.value) {
+				return true
+			}
+		}
+		return false
+	}
+	v, ok := Lookup(record, field)
+	if !ok {
+		return false
+	}
+	if vs, ok := v.([]interface{}); ok {
+		for _, e := range vs {
+			if fn(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(v)
+}
+
+type keyValue struct {
+	key   string
+	value interface{}
+}
+
+func flatten(prefix string, m map[string]interface{}, kvs []keyValue) []keyValue {
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			kvs = flatten(prefix+k+".", sub, kvs)
+			continue
+		}
+		kvs = append(kvs, keyValue{prefix + k, v})
+	}
+	return kvs
+}
+
+// Lookup 取记录中字段的值，嵌套的字段以 . 连接
+func Lookup(record map[string]interface{}, field string) (interface{}, bool) {
+	if v, ok := record[field]; ok {
+		return v, true
+	}
+	for i := strings.Index(field, "."); i > 0; i = nextDot(field, i) {
+		if sub, ok := record[field[:i]].(map[string]interface{}); ok {
+			if v, ok := Lookup(sub, field[i+1:]); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func nextDot(s string, i int) int {
+	j := strings.Index(s[i+1:], ".")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func matchTerm(t *Term, v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	if t.Wildcard && t.Value == "*" {
+		return true
+	}
+	switch x := v.(type) {
+	case float64:
+		if !t.Wildcard && !t.Regexp {
+			f, err := strconv.ParseFloat(t.Value, 64)
+			return err == nil && f == x
+		}
+		return matchString(t, strconv.FormatFloat(x, 'f', -1, 64))
+	case bool:
+		return strings.EqualFold(t.Value, strconv.FormatBool(x))
+	case string:
+		return matchString(t, x)
+	case map[string]interface{}:
+		return false
+	}
+	return matchString(t, fmt.Sprint(v))
+}
+
+func matchString(t *Term, s string) bool {
+	switch {
+	case t.Regexp:
+		re, err := regexp.Compile("(?i)^(?:" + t.Value + ")$")
+		return err == nil && anyToken(s, re.MatchString)
+	case t.Wildcard:
+		re := globRegexp(t.Value)
+		return anyToken(s, re.MatchString)
+	case t.Fuzzy != "" && !t.Phrase:
+		max := 2
+		if n, err := strconv.Atoi(strings.TrimPrefix(t.Fuzzy, "~")); err == nil {
+			max = n
+		}
+		value := strings.ToLower(t.Value)
+		return anyToken(s, func(token string) bool { return editDistance(token, value) <= max })
+	}
+	if strings.EqualFold(s, t.Value) {
+		return true
+	}
+	return containsTokens(tokenize(s), tokenize(t.Value))
+}
+
+// anyToken 整个值或其中的任意一个单词满足 fn
+func anyToken(s string, fn func(string) bool) bool {
+	if fn(strings.ToLower(s)) {
+		return true
+	}
+	for _, token := range tokenize(s) {
+		if fn(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize 按非字母数字的字符切分为小写的单词，每个汉字为一个单词
+func tokenize(s string) []string {
+	spans := tokenSpans(s)
+	tokens := make([]string, len(spans))
+	for i, t := range spans {
+		tokens[i] = t.text
+	}
+	return tokens
+}
+
+// containsTokens words 是否在 tokens 中连续出现
+func containsTokens(tokens, words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	for i := 0; i+len(words) <= len(tokens); i++ {
+		j := 0
+		for j < len(words) && tokens[i+j] == words[j] {
+			j++
+		}
+		if j == len(words) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp 将 * ? 通配符转换为不区分大小写的正则
+func globRegexp(glob string) *regexp.Regexp {
+	var b bytes.Buffer
+	b.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func matchRange(r *Range, v interface{}) bool {
+	if r.Lo != "*" {
+		c, ok := compare(v, r.Lo)
+		if !ok || c < 0 || c == 0 && !r.IncLo {
+			return false
+		}
+	}
+	if r.Hi != "*" {
+		c, ok := compare(v, r.Hi)
+		if !ok || c > 0 || c == 0 && !r.IncHi {
+			return false
+		}
+	}
+	return v != nil
+}
+
+// compare 比较 v 与范围的边界 bound ，v 大于 bound 时返回正数。
+// 数值按数值比较，能解析为时间的按时间比较，否则按字符串比较
+func compare(v interface{}, bound string) (int, bool) {
+	switch x := v.(type) {
+	case nil, map[string]interface{}:
+		return 0, false
+	case float64:
+		if t, err := parseBound(bound); err == nil {
+			// 毫秒时间戳
+			return compareFloat(x, float64(t.UnixNano()/int64(time.Millisecond))), true
+		}
+		f, err := strconv.ParseFloat(bound, 64)
+		return compareFloat(x, f), err == nil
+	case string:
+		if t, err := parseBound(bound); err == nil && !isNumber(x) {
+			if vt, err := ParseDate(strings.TrimSpace(x)); err == nil {
+				return compareTime(vt, t), true
+			}
+		}
+		if f, err := strconv.ParseFloat(bound, 64); err == nil {
+			if vf, err := strconv.ParseFloat(x, 64); err == nil {
+				return compareFloat(vf, f), true
+			}
+		}
+		return strings.Compare(x, bound), true
+	}
+	return strings.Compare(fmt.Sprint(v), bound), true
+}
+
+// parseBound 时间范围的上下界，另外支持 now-1h 等相对时间
+func parseBound(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "now") {
+		return DateMath(s, time.Now().In(location))
+	}
+	if isNumber(s) {
+		return time.Time{}, fmt.Errorf("not a date: %s", s)
+	}
+	return ParseDate(s)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
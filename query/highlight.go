@@ -0,0 +1,158 @@
+package query
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Highlighter 找出字段值中与查询条件匹配的部分，用于在终端中高亮显示。
+// 只考虑需要满足的词、短语、通配符和正则，忽略 NOT 、- 下的条件和范围
+type Highlighter struct {
+	terms []highlightTerm
+}
+
+type highlightTerm struct {
+	field string   // 为空表示任意字段
+	words []string // 需连续出现的单词
+	re    *regexp.Regexp
+}
+
+// NewHighlighter 从语法树中收集要高亮的词。没有可高亮的词时返回 nil
+func NewHighlighter(n Node) *Highlighter {
+	h := &Highlighter{}
+	h.collect(n, "")
+	if len(h.terms) == 0 {
+		return nil
+	}
+	return h
+}
+
+func (h *Highlighter) collect(n Node, field string) {
+	switch t := n.(type) {
+	case *Term:
+		if t.Field != "" {
+			field = t.Field
+		}
+		if field == "_exists_" || t.Wildcard && t.Value == "*" {
+			return
+		}
+		ht := highlightTerm{field: field}
+		switch {
+		case t.Regexp:
+			re, err := regexp.Compile("(?i)^(?:" + t.Value + ")$")
+			if err != nil {
+				return
+			}
+			ht.re = re
+		case t.Wildcard:
+			ht.re = globRegexp(t.Value)
+		default:
+			ht.words = tokenize(t.Value)
+			if len(ht.words) == 0 {
+				return
+			}
+		}
+		h.terms = append(h.terms, ht)
+	case *Group:
+		if t.Field != "" {
+			field = t.Field
+		}
+		h.collect(t.X, field)
+	case *Unary:
+		if t.Op == "+" {
+			h.collect(t.X, field)
+		}
+	case *Binary:
+		h.collect(t.Left, field)
+		h.collect(t.Right, field)
+	}
+}
+
+// Spans 返回 s 中需要高亮的部分，为字节偏移 [start, end) ，按位置排序且互不重叠
+func (h *Highlighter) Spans(field, s string) (spans [][2]int) {
+	if h == nil || s == "" {
+		return
+	}
+	tokens := tokenSpans(s)
+	for _, t := range h.terms {
+		if t.field != "" && t.field != field {
+			continue
+		}
+		if t.re != nil {
+			if t.re.MatchString(s) {
+				return [][2]int{{0, len(s)}}
+			}
+			for _, tok := range tokens {
+				if t.re.MatchString(tok.text) {
+					spans = append(spans, [2]int{tok.start, tok.end})
+				}
+			}
+			continue
+		}
+		for i := 0; i+len(t.words) <= len(tokens); i++ {
+			j := 0
+			for j < len(t.words) && tokens[i+j].text == t.words[j] {
+				j++
+			}
+			if j == len(t.words) {
+				spans = append(spans, [2]int{tokens[i].start, tokens[i+j-1].end})
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+func mergeSpans(spans [][2]int) [][2]int {
+	if len(spans) < 2 {
+		return spans
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	merged := spans[:1]
+	for _, sp := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if sp[0] <= last[1] {
+			if sp[1] > last[1] {
+				last[1] = sp[1]
+			}
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	return merged
+}
+
+type tokenSpan struct {
+	text       string // 小写
+	start, end int
+}
+
+// tokenSpans 同 tokenize ，并记录每个单词在 s 中的字节偏移
+func tokenSpans(s string) []tokenSpan {
+	var tokens []tokenSpan
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, tokenSpan{strings.ToLower(s[start:end]), start, end})
+			start = -1
+		}
+	}
+	for i, r := range s {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush(i)
+			end := i + utf8.RuneLen(r)
+			tokens = append(tokens, tokenSpan{s[i:end], i, end})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(s))
+	return tokens
+}
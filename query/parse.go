@@ -0,0 +1,531 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	tEOF = iota
+	tLParen
+	tRParen
+	tLBracket // [ 或 {
+	tRBracket // ] 或 }
+	tColon
+	tAnd
+	tOr
+	tNot
+	tPlus
+	tMinus
+	tTo
+	tTerm
+	tPhrase
+	tRegexp
+	tTilde
+	tCaret
+)
+
+type token struct {
+	kind     int
+	text     string // 原始写法
+	val      string // 去掉引号和转义后的值
+	wildcard bool
+	pos      int
+}
+
+func (t token) describe() string {
+	if t.kind == tEOF {
+		return "查询语句结尾"
+	}
+	return quoted(t.text)
+}
+
+func quoted(s string) string {
+	return "\"" + s + "\""
+}
+
+type lexer struct {
+	src []rune
+	i   int
+	// 上一个 token ，用于判断 + - ! / 是否出现在词的开头
+	last int
+}
+
+func (lx *lexer) col() int { return lx.i + 1 }
+
+func (lx *lexer) skipSpace() {
+	for lx.i < len(lx.src) && unicode.IsSpace(lx.src[lx.i]) {
+		lx.i++
+	}
+}
+
+func (lx *lexer) next() (tok token, err error) {
+	spaced := lx.i
+	lx.skipSpace()
+	spaced = lx.i - spaced
+	defer func() { lx.last = tok.kind }()
+	if lx.i >= len(lx.src) {
+		return token{kind: tEOF, pos: lx.col()}, nil
+	}
+	pos := lx.col()
+	c := lx.src[lx.i]
+	// 紧跟在词后的 ~ ^ : 以及词开头的 + - ! /
+	atStart := spaced > 0 || lx.i == 0 || lx.last == tLParen || lx.last == tColon ||
+		lx.last == tPlus || lx.last == tMinus || lx.last == tNot
+	single := func(kind int) (token, error) {
+		lx.i++
+		return token{kind: kind, text: string(c), pos: pos}, nil
+	}
+	switch c {
+	case '(':
+		return single(tLParen)
+	case ')':
+		return single(tRParen)
+	case '[', '{':
+		return single(tLBracket)
+	case ']', '}':
+		return single(tRBracket)
+	case ':':
+		return single(tColon)
+	case '"':
+		return lx.phrase()
+	case '~', '^':
+		lx.i++
+		start := lx.i
+		for lx.i < len(lx.src) && (lx.src[lx.i] >= '0' && lx.src[lx.i] <= '9' || lx.src[lx.i] == '.') {
+			lx.i++
+		}
+		kind := tTilde
+		if c == '^' {
+			kind = tCaret
+			if lx.i == start {
+				return tok, &Error{Col: pos, Msg: "^ 后应为数字"}
+			}
+		}
+		return token{kind: kind, text: string(lx.src[start-1 : lx.i]), val: string(lx.src[start:lx.i]), pos: pos}, nil
+	case '&', '|':
+		if lx.i+1 < len(lx.src) && lx.src[lx.i+1] == c {
+			lx.i += 2
+			if c == '&' {
+				return token{kind: tAnd, text: "&&", pos: pos}, nil
+			}
+			return token{kind: tOr, text: "||", pos: pos}, nil
+		}
+	}
+	if atStart {
+		switch c {
+		case '+':
+			return single(tPlus)
+		case '!':
+			return single(tNot)
+		case '-':
+			// field:-5 中的 - 为负号
+			if !(lx.last == tColon && lx.i+1 < len(lx.src) && unicode.IsDigit(lx.src[lx.i+1])) {
+				return single(tMinus)
+			}
+		case '/':
+			return lx.regexp()
+		}
+	}
+	return lx.term()
+}
+
+func (lx *lexer) phrase() (tok token, err error) {
+	pos := lx.col()
+	start := lx.i
+	lx.i++
+	var val bytes.Buffer
+	for lx.i < len(lx.src) {
+		c := lx.src[lx.i]
+		switch c {
+		case '\\':
+			if lx.i+1 >= len(lx.src) {
+				return tok, &Error{Col: lx.col(), Msg: "转义符 \\ 后缺少字符"}
+			}
+			val.WriteRune(lx.src[lx.i+1])
+			lx.i += 2
+			continue
+		case '"':
+			lx.i++
+			return token{kind: tPhrase, text: string(lx.src[start:lx.i]), val: val.String(), pos: pos}, nil
+		}
+		val.WriteRune(c)
+		lx.i++
+	}
+	return tok, &Error{Col: pos, Msg: "引号未闭合"}
+}
+
+func (lx *lexer) regexp() (tok token, err error) {
+	pos := lx.col()
+	start := lx.i
+	lx.i++
+	for lx.i < len(lx.src) {
+		switch lx.src[lx.i] {
+		case '\\':
+			lx.i += 2
+			continue
+		case '/':
+			lx.i++
+			text := string(lx.src[start:lx.i])
+			return token{kind: tRegexp, text: text, val: text[1 : len(text)-1], pos: pos}, nil
+		}
+		lx.i++
+	}
+	return tok, &Error{Col: pos, Msg: "正则表达式的 / 未闭合"}
+}
+
+func (lx *lexer) term() (tok token, err error) {
+	pos := lx.col()
+	start := lx.i
+	var val bytes.Buffer
+	wildcard := false
+loop:
+	for lx.i < len(lx.src) {
+		c := lx.src[lx.i]
+		switch {
+		case c == '\\':
+			if lx.i+1 >= len(lx.src) {
+				return tok, &Error{Col: lx.col(), Msg: "转义符 \\ 后缺少字符"}
+			}
+			val.WriteRune(lx.src[lx.i+1])
+			lx.i += 2
+			continue
+		case unicode.IsSpace(c) || strings.ContainsRune(`()[]{}:"^~`, c):
+			break loop
+		case c == '*' || c == '?':
+			wildcard = true
+		}
+		val.WriteRune(c)
+		lx.i++
+	}
+	if lx.i == start {
+		return tok, &Error{Col: pos, Msg: "无法识别的字符 " + quoted(string(lx.src[lx.i]))}
+	}
+	text := string(lx.src[start:lx.i])
+	tok = token{kind: tTerm, text: text, val: val.String(), wildcard: wildcard, pos: pos}
+	switch text {
+	case "AND":
+		tok.kind = tAnd
+	case "OR":
+		tok.kind = tOr
+	case "NOT":
+		tok.kind = tNot
+	case "TO":
+		tok.kind = tTo
+	}
+	return
+}
+
+// bound 读取范围的上下界。日期中的 : 等字符不需要转义，直到空白或 ] } 为止
+func (lx *lexer) bound() (tok token, err error) {
+	lx.skipSpace()
+	pos := lx.col()
+	if lx.i < len(lx.src) && lx.src[lx.i] == '"' {
+		return lx.phrase()
+	}
+	start := lx.i
+	for lx.i < len(lx.src) && !unicode.IsSpace(lx.src[lx.i]) && !strings.ContainsRune("]}", lx.src[lx.i]) {
+		lx.i++
+	}
+	if lx.i == start {
+		if lx.i >= len(lx.src) {
+			return token{kind: tEOF, pos: pos}, nil
+		}
+		return token{kind: tRBracket, text: string(lx.src[lx.i]), pos: pos}, nil
+	}
+	text := string(lx.src[start:lx.i])
+	return token{kind: tTerm, text: text, val: text, pos: pos}, nil
+}
+
+type parser struct {
+	lx     *lexer
+	tok    token
+	peeked bool
+}
+
+// Parse 将查询语句解析为语法树。语句为空时返回 nil, nil
+func Parse(q string) (n Node, err error) {
+	p := &parser{lx: &lexer{src: []rune(q)}}
+	tok, err := p.peek()
+	if err != nil || tok.kind == tEOF {
+		return
+	}
+	if n, err = p.or(); err != nil {
+		return nil, err
+	}
+	if tok, err = p.next(); err != nil {
+		return nil, err
+	}
+	if tok.kind != tEOF {
+		if tok.kind == tRParen {
+			return nil, &Error{Col: tok.pos, Msg: "多余的 )"}
+		}
+		return nil, &Error{Col: tok.pos, Msg: "意外的 " + tok.describe()}
+	}
+	return
+}
+
+func (p *parser) peek() (token, error) {
+	if !p.peeked {
+		tok, err := p.lx.next()
+		if err != nil {
+			return tok, err
+		}
+		p.tok, p.peeked = tok, true
+	}
+	return p.tok, nil
+}
+
+func (p *parser) next() (token, error) {
+	tok, err := p.peek()
+	p.peeked = false
+	return tok, err
+}
+
+func (p *parser) or() (n Node, err error) {
+	if n, err = p.and(); err != nil {
+		return
+	}
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind != tOr {
+			return n, nil
+		}
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		n = &Binary{Op: "OR", Left: n, Right: right, pos: n.Pos()}
+	}
+}
+
+// and AND 以及没有运算符的相邻子句，优先级高于 OR
+func (p *parser) and() (n Node, err error) {
+	if n, err = p.clause(); err != nil {
+		return
+	}
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		b := &Binary{Op: "AND", Left: n, pos: n.Pos()}
+		switch tok.kind {
+		case tEOF, tRParen, tOr:
+			return n, nil
+		case tAnd:
+			p.next()
+		default:
+			b.Op, b.Implicit = "", true
+		}
+		if b.Right, err = p.clause(); err != nil {
+			return nil, err
+		}
+		n = b
+	}
+}
+
+func (p *parser) clause() (Node, error) {
+	tok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case tNot, tPlus, tMinus:
+		p.next()
+		x, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Op: tok.text, X: x, pos: tok.pos}, nil
+	case tTerm:
+		p.next()
+		next, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if next.kind == tColon {
+			p.next()
+			return p.value(tok.val, tok.pos, next.pos)
+		}
+		return p.term(tok, "", tok.pos)
+	case tLParen, tPhrase, tRegexp, tLBracket:
+		return p.value("", tok.pos, tok.pos)
+	case tEOF:
+		return nil, &Error{Col: tok.pos, Msg: "查询语句不完整"}
+	}
+	return nil, &Error{Col: tok.pos, Msg: "意外的 " + tok.describe()}
+}
+
+// value 字段后的值，或未指定字段的短语、正则、范围和括号
+func (p *parser) value(field string, pos, colon int) (Node, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case tLParen:
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if end.kind != tRParen {
+			return nil, &Error{Col: tok.pos, Msg: "括号未闭合"}
+		}
+		g := &Group{Field: field, X: x, pos: pos}
+		g.Boost, err = p.boost()
+		return g, err
+	case tLBracket:
+		return p.rangeValue(field, pos, tok)
+	case tTerm, tPhrase, tRegexp:
+		return p.term(tok, field, pos)
+	case tEOF:
+		return nil, &Error{Col: colon, Msg: "字段 " + field + " 后缺少值"}
+	}
+	return nil, &Error{Col: tok.pos, Msg: "意外的 " + tok.describe()}
+}
+
+func (p *parser) term(tok token, field string, pos int) (Node, error) {
+	t := &Term{
+		Field:    field,
+		Value:    tok.val,
+		Phrase:   tok.kind == tPhrase,
+		Wildcard: tok.wildcard,
+		Regexp:   tok.kind == tRegexp,
+		raw:      tok.text,
+		pos:      pos,
+		vpos:     tok.pos,
+	}
+	next, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if next.kind == tTilde {
+		p.next()
+		t.Fuzzy = next.text
+	}
+	t.Boost, err = p.boost()
+	return t, err
+}
+
+func (p *parser) boost() (string, error) {
+	next, err := p.peek()
+	if err != nil || next.kind != tCaret {
+		return "", err
+	}
+	p.next()
+	return next.val, nil
+}
+
+func (p *parser) rangeValue(field string, pos int, open token) (Node, error) {
+	if p.peeked {
+		// [ 之后的内容按范围的规则读取
+		return nil, &Error{Col: p.tok.pos, Msg: "意外的 " + p.tok.describe()}
+	}
+	r := &Range{Field: field, IncLo: open.text == "[", pos: pos}
+	lo, err := p.lx.bound()
+	if err != nil {
+		return nil, err
+	}
+	if lo.kind != tTerm && lo.kind != tPhrase {
+		return nil, &Error{Col: open.pos, Msg: "范围缺少下界，应为 [a TO b]"}
+	}
+	to, err := p.lx.bound()
+	if err != nil {
+		return nil, err
+	}
+	if to.kind != tTerm || to.text != "TO" {
+		return nil, &Error{Col: to.pos, Msg: "范围缺少 TO ，应为 [a TO b]"}
+	}
+	hi, err := p.lx.bound()
+	if err != nil {
+		return nil, err
+	}
+	if hi.kind != tTerm && hi.kind != tPhrase {
+		return nil, &Error{Col: to.pos, Msg: "范围缺少上界，应为 [a TO b]"}
+	}
+	end, err := p.lx.bound()
+	if err != nil {
+		return nil, err
+	}
+	if end.kind != tRBracket {
+		return nil, &Error{Col: open.pos, Msg: "范围未闭合，应以 ] 或 } 结尾"}
+	}
+	p.lx.i++
+	p.lx.last = tRBracket
+	r.Lo, r.loPos = lo.val, lo.pos
+	r.Hi, r.hiPos = hi.val, hi.pos
+	r.IncHi = end.text == "]"
+	return r, nil
+}
+
+// Error 查询语句中的错误，Col 为所在的列，从 1 开始
+type Error struct {
+	Col int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("第 %d 列: %s", e.Col, e.Msg)
+}
+
+// ErrorList 多个错误，如检查字段时发现的所有问题
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Describe 在错误信息后显示查询语句，并用 ^ 标出出错的位置
+func Describe(q string, err error) string {
+	var errs ErrorList
+	switch e := err.(type) {
+	case *Error:
+		errs = ErrorList{e}
+	case ErrorList:
+		errs = e
+	default:
+		return err.Error()
+	}
+	var b bytes.Buffer
+	rs := []rune(q)
+	for i, e := range errs {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		col := e.Col - 1
+		if col > len(rs) {
+			col = len(rs)
+		}
+		fmt.Fprintf(&b, "ERROR: %s\n  %s\n  %s^", e.Error(), q, strings.Repeat(" ", width(rs[:col])))
+	}
+	return b.String()
+}
+
+// width 显示宽度，中日韩字符占两列
+func width(rs []rune) int {
+	n := 0
+	for _, r := range rs {
+		if r >= 0x1100 && (unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hangul, r) ||
+			unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || r >= 0xff00 && r <= 0xff60) {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
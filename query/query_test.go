@@ -0,0 +1,350 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		q    string
+		want string
+	}{
+		{"status:500", "status:500"},
+		{"status:500 AND method:GET", "status:500 AND method:GET"},
+		{"a b OR c", "a b OR c"},
+		{"a && b || !c", "a AND b OR !c"},
+		{`msg:"time out"~2^3`, `msg:"time out"~2^3`},
+		{"host:web* -level:debug", "host:web* -level:debug"},
+		{"path:/ap[ip]\\/v.*/", "path:/ap[ip]\\/v.*/"},
+		{"latency:[100 TO 200}", "latency:[100 TO 200}"},
+		{"ts:[2017-04-06T17:40:30+0800 TO *]", "ts:[2017-04-06T17:40:30+0800 TO *]"},
+		{"level:(warn OR error) NOT host:a-b", "level:(warn OR error) NOT host:a-b"},
+		{"code:-5", "code:-5"},
+		{`url:http\://a`, `url:http\://a`},
+		{"", ""},
+	}
+	for _, c := range cases {
+		n, err := Parse(c.q)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.q, err)
+			continue
+		}
+		got := ""
+		if n != nil {
+			got = n.String()
+		}
+		if got != c.want {
+			t.Errorf("Parse(%q) = %q, want %q", c.q, got, c.want)
+		}
+	}
+}
+
+func TestParseStructure(t *testing.T) {
+	n, err := Parse(`a OR b AND c:"x y"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	or, ok := n.(*Binary)
+	if !ok || or.Op != "OR" {
+		t.Fatalf("root = %#v, want OR", n)
+	}
+	and, ok := or.Right.(*Binary)
+	if !ok || and.Op != "AND" {
+		t.Fatalf("right = %#v, want AND", or.Right)
+	}
+	term, ok := and.Right.(*Term)
+	if !ok || term.Field != "c" || term.Value != "x y" || !term.Phrase {
+		t.Fatalf("term = %#v", and.Right)
+	}
+	if term.Pos() != 12 {
+		t.Errorf("term pos = %d, want 12", term.Pos())
+	}
+}
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		q   string
+		col int
+	}{
+		{"status:", 7},
+		{"(a OR b", 1},
+		{"a OR b)", 7},
+		{`msg:"abc`, 5},
+		{"a AND", 6},
+		{"x:[1 TO 2", 3},
+		{"x:[1 2]", 6},
+		{"a:b:c", 4},
+		{"a^", 2},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.q)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Parse(%q) err = %v, want *Error", c.q, err)
+			continue
+		}
+		if e.Col != c.col {
+			t.Errorf("Parse(%q) col = %d, want %d (%s)", c.q, e.Col, c.col, e.Msg)
+		}
+	}
+}
+
+var testSchema = []logdb.RepoSchemaEntry{
+	{Key: "timestamp", ValueType: "date"},
+	{Key: "status", ValueType: "long"},
+	{Key: "latency", ValueType: "float"},
+	{Key: "ok", ValueType: "boolean"},
+	{Key: "method", ValueType: "string"},
+	{Key: "req", ValueType: "object", Schemas: []logdb.RepoSchemaEntry{
+		{Key: "host", ValueType: "string"},
+	}},
+}
+
+func TestSetLocation(t *testing.T) {
+	local := time.Local
+	defer func() {
+		time.Local = local
+		SetLocation(local)
+	}()
+	// 本机时区与 SetLocation 的时区不同时，不带时区的时间按 SetLocation 的时区解析
+	time.Local = time.FixedZone("CST", 8*3600)
+	SetLocation(time.UTC)
+	got, err := ParseDate("2024-01-01T00:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("ParseDate = %v, want %v", got, want)
+	}
+	n, _ := Parse("timestamp:[2024-01-01T00:00:00 TO 2024-01-01T00:00:00]")
+	if !Match(n, map[string]interface{}{"timestamp": "2024-01-01T00:00:00Z"}) {
+		t.Error("zoneless range bounds should be parsed in UTC")
+	}
+	if _, err := Check("timestamp:[2024-01-01T08:00:00+0800 TO 2024-01-01T00:00:00]", testSchema); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	if d, _ := DateMath("now/d", time.Now().In(location)); d.Location() != time.UTC || d.Hour() != 0 {
+		t.Errorf("now/d = %v, want midnight in UTC", d)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"status:500 AND method:GET",
+		"status:[400 TO 499] latency:{* TO 1.5]",
+		"timestamp:[2017-04-06T17:40:30+0800 TO now]",
+		"timestamp:[now-1d/d TO now+1h]",
+		"req.host:web* ok:true",
+		"status:* error",
+		"method:(GET OR POST)",
+	}
+	for _, q := range valid {
+		if _, err := Check(q, testSchema); err != nil {
+			t.Errorf("Check(%q): %v", q, err)
+		}
+	}
+
+	invalid := []struct {
+		q    string
+		col  int
+		want string
+	}{
+		{"Status:500", 1, "是否为 status"},
+		{"status:abc", 8, "不是整数"},
+		{"latency:[1 TO x]", 15, "不是数字"},
+		{"status:[500 TO 400]", 9, "大于上界"},
+		{"status:50*", 8, "不支持通配符"},
+		{"ok:yes", 4, "true 或 false"},
+		{"timestamp:[yesterday TO *]", 12, "无法识别时间"},
+		{"timestamp:[now-1x TO *]", 12, "无法识别相对时间"},
+		{"timestamp:[now TO now-1h]", 12, "晚于上界"},
+		{"req:x", 1, "请使用其子字段"},
+		{"a AND foo:(x OR y)", 7, "没有字段 foo"},
+	}
+	for _, c := range invalid {
+		_, err := Check(c.q, testSchema)
+		errs, ok := err.(ErrorList)
+		if !ok || len(errs) != 1 {
+			t.Errorf("Check(%q) err = %v, want one error", c.q, err)
+			continue
+		}
+		if errs[0].Col != c.col || !strings.Contains(errs[0].Msg, c.want) {
+			t.Errorf("Check(%q) = %v, want col %d containing %q", c.q, errs[0], c.col, c.want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	_, err := Parse("msg:中文 AND )")
+	got := Describe("msg:中文 AND )", err)
+	want := "ERROR: 第 12 列: 意外的 \")\"\n  msg:中文 AND )\n               ^"
+	if got != want {
+		t.Errorf("Describe = %q, want %q", got, want)
+	}
+}
+
+func TestClause(t *testing.T) {
+	cases := []struct {
+		op, spec string
+		want     string
+	}{
+		{"eq", `msg=say "hi" \o/`, `msg:"say \"hi\" \\o/"`},
+		{"ne", "level=debug", `NOT level:"debug"`},
+		{"in", "status=500,502", `status:("500" OR "502")`},
+		{"prefix", "path=/api/v1 x", `path:\/api\/v1\ x*`},
+		{"range", "latency=100..", "latency:[100 TO *]"},
+		{"range", "ts=2017-04-06T17:40:30+0800..2017-04-06T18:40:30+0800", "ts:[2017-04-06T17:40:30+0800 TO 2017-04-06T18:40:30+0800]"},
+		{"exists", "req.host", `req.host:*`},
+	}
+	for _, c := range cases {
+		got, err := Clause(c.op, c.spec)
+		if err != nil {
+			t.Errorf("Clause(%s, %q): %v", c.op, c.spec, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Clause(%s, %q) = %s, want %s", c.op, c.spec, got, c.want)
+		}
+		if _, err := Parse(got); err != nil {
+			t.Errorf("Parse(%s): %v", got, err)
+		}
+	}
+
+	n, _ := Parse(Prefix("path", "/api/v1 x"))
+	if term := n.(*Term); term.Value != "/api/v1 x*" || term.Field != "path" {
+		t.Errorf("prefix term = %#v", term)
+	}
+
+	for _, spec := range []string{"novalue", "=x"} {
+		if _, err := Clause("eq", spec); err == nil {
+			t.Errorf("Clause(eq, %q) should fail", spec)
+		}
+	}
+	if _, err := Clause("range", "x=1"); err == nil {
+		t.Error("Clause(range, x=1) should fail")
+	}
+}
+
+func TestAnd(t *testing.T) {
+	got := And("a OR b", "", `status:"500"`, "NOT level:debug")
+	want := `(a OR b) AND status:"500" AND NOT level:debug`
+	if got != want {
+		t.Errorf("And = %s, want %s", got, want)
+	}
+	if got := And("", "x:1"); got != "x:1" {
+		t.Errorf("And = %s, want x:1", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	record := map[string]interface{}{
+		"timestamp": "2024-01-01T10:00:00+08:00",
+		"status":    float64(500),
+		"host":      "web-1",
+		"msg":       "upstream Time Out after 3s",
+		"ok":        false,
+		"tags":      []interface{}{"a", "b"},
+		"req":       map[string]interface{}{"path": "/api/v1/users"},
+	}
+	cases := []struct {
+		q    string
+		want bool
+	}{
+		{"status:500", true},
+		{"status:200", false},
+		{`msg:"time out"`, true},
+		{`msg:"out time"`, false},
+		{"msg:timeout", false},
+		{"msg:tim~1", true},
+		{"host:web*", true},
+		{"host:web-1", true},
+		{"host:/web-[0-9]/", true},
+		{"req.path:users", true},
+		{"req.path:\\/api\\/v1\\/users", true},
+		{"status:[500 TO 599]", true},
+		{"status:{500 TO 599]", false},
+		{"timestamp:[2024-01-01T01:59:00Z TO 2024-01-01T02:01:00Z]", true},
+		{"timestamp:[2024-01-01T10:00:01+0800 TO *]", false},
+		{"ok:false", true},
+		{"tags:b", true},
+		{"upstream", true},
+		{"missing:*", false},
+		{"_exists_:host", true},
+		{"status:500 AND host:web-2", false},
+		{"status:200 OR host:web-1", true},
+		{"NOT status:200", true},
+		{"status:500 -host:web-1", false},
+		{"+status:500 host:nothing", true},
+		{"status:200 host:nothing", false},
+		{"host:(web-2 OR web-1)", true},
+	}
+	for _, c := range cases {
+		n, err := Parse(c.q)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.q, err)
+			continue
+		}
+		if got := Match(n, record); got != c.want {
+			t.Errorf("Match(%q) = %v, want %v", c.q, got, c.want)
+		}
+	}
+
+	// now 开头的相对时间，时间字段为字符串或毫秒时间戳
+	now := time.Now()
+	relative := []struct {
+		q    string
+		ago  time.Duration
+		want bool
+	}{
+		{"timestamp:[now-1h TO now]", 10 * time.Minute, true},
+		{"timestamp:[now-1h TO now]", 48 * time.Hour, false},
+		{"timestamp:[now-3d TO now-1d]", 48 * time.Hour, true},
+		{"timestamp:[now-3d TO now-1d]", 10 * time.Minute, false},
+		{"timestamp:{now-1h TO *]", 2 * time.Hour, false},
+		{"timestamp:[now-7d/d TO now]", 24 * time.Hour, true},
+	}
+	for _, c := range relative {
+		n, err := Parse(c.q)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.q, err)
+			continue
+		}
+		ts := now.Add(-c.ago)
+		for _, v := range []interface{}{ts.Format(time.RFC3339), float64(ts.UnixNano() / int64(time.Millisecond))} {
+			if got := Match(n, map[string]interface{}{"timestamp": v}); got != c.want {
+				t.Errorf("Match(%q) with timestamp %v = %v, want %v", c.q, v, got, c.want)
+			}
+		}
+	}
+}
+
+func TestHighlighter(t *testing.T) {
+	n, err := Parse(`msg:"time out" OR host:web* NOT msg:ok 中文`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHighlighter(n)
+	cases := []struct {
+		field, s string
+		want     [][2]int
+	}{
+		{"msg", "upstream Time Out, ok", [][2]int{{9, 17}}},
+		{"host", "web-1", [][2]int{{0, 5}}},
+		{"other", "time out", nil},
+		{"other", "含中文", [][2]int{{3, 9}}},
+	}
+	for _, c := range cases {
+		got := h.Spans(c.field, c.s)
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("Spans(%s, %q) = %v, want %v", c.field, c.s, got, c.want)
+		}
+	}
+	if n, _ := Parse("NOT a"); NewHighlighter(n) != nil {
+		t.Error("NOT a should have nothing to highlight")
+	}
+}
@@ -0,0 +1,281 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/pandora-go-sdk/logdb"
+)
+
+// DateLayouts 范围查询中可以使用的时间格式，另外支持毫秒时间戳和 now 开头的相对时间
+var DateLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05.000-0700",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Check 解析查询语句并根据 schema 检查
+func Check(q string, schema []logdb.RepoSchemaEntry) (Node, error) {
+	n, err := Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	return n, Validate(n, schema)
+}
+
+// Validate 检查语法树中的字段是否存在于 schema 中，值是否符合字段的类型。
+// 返回的错误为 ErrorList ，包含所有发现的问题
+func Validate(n Node, schema []logdb.RepoSchemaEntry) error {
+	var errs ErrorList
+	// field:(a OR b) 中的字段只在 Group 处检查一次
+	lookup := func(field, own string, pos int) *logdb.RepoSchemaEntry {
+		if own == "" {
+			if e := LookupField(schema, field); e != nil && len(e.Schemas) == 0 {
+				return e
+			}
+			return nil
+		}
+		return checkField(&errs, schema, field, pos)
+	}
+	Walk(n, func(n Node, field string) {
+		switch t := n.(type) {
+		case *Term:
+			if field == "" {
+				return
+			}
+			e := lookup(field, t.Field, t.pos)
+			if e == nil {
+				return
+			}
+			if t.Wildcard && t.Value == "*" {
+				// field:* 表示字段存在
+				return
+			}
+			checkTerm(&errs, e, field, t)
+		case *Range:
+			if field == "" {
+				return
+			}
+			if e := lookup(field, t.Field, t.pos); e != nil {
+				checkRange(&errs, e, field, t)
+			}
+		case *Group:
+			if t.Field != "" {
+				checkField(&errs, schema, t.Field, t.pos)
+			}
+		}
+	})
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// checkField 查找字段，object 等类型的字段需要使用其子字段
+func checkField(errs *ErrorList, schema []logdb.RepoSchemaEntry, field string, pos int) *logdb.RepoSchemaEntry {
+	if field == "_exists_" || strings.ContainsAny(field, "*?") {
+		return nil
+	}
+	e := LookupField(schema, field)
+	if e == nil {
+		msg := "repo 中没有字段 " + field
+		if s := suggest(schema, field); s != "" {
+			msg += " ，是否为 " + s
+		}
+		*errs = append(*errs, &Error{Col: pos, Msg: msg})
+		return nil
+	}
+	if len(e.Schemas) > 0 {
+		*errs = append(*errs, &Error{Col: pos, Msg: fmt.Sprintf("字段 %s 为 %s 类型，请使用其子字段，如 %s.%s",
+			field, e.ValueType, field, e.Schemas[0].Key)})
+		return nil
+	}
+	return e
+}
+
+// LookupField 查找字段，嵌套的字段以 . 连接，如 req.header.host
+func LookupField(schema []logdb.RepoSchemaEntry, field string) *logdb.RepoSchemaEntry {
+	for i := range schema {
+		e := &schema[i]
+		if e.Key == field {
+			return e
+		}
+		if len(e.Schemas) > 0 && strings.HasPrefix(field, e.Key+".") {
+			if sub := LookupField(e.Schemas, strings.TrimPrefix(field, e.Key+".")); sub != nil {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// fieldNames 所有字段名，包括嵌套的子字段
+func fieldNames(schema []logdb.RepoSchemaEntry, prefix string) (names []string) {
+	for _, e := range schema {
+		names = append(names, prefix+e.Key)
+		if len(e.Schemas) > 0 {
+			names = append(names, fieldNames(e.Schemas, prefix+e.Key+".")...)
+		}
+	}
+	return
+}
+
+// suggest 找出与 field 最接近的字段名，如大小写不同或拼写错误
+func suggest(schema []logdb.RepoSchemaEntry, field string) string {
+	// 允许的差异随字段名长度增加，最多 2 个字符
+	best, bestDist := "", minInt(len([]rune(field))/3, 2)+1
+	for _, name := range fieldNames(schema, "") {
+		if strings.EqualFold(name, field) {
+			return name
+		}
+		if d := editDistance(name, field); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func checkTerm(errs *ErrorList, e *logdb.RepoSchemaEntry, field string, t *Term) {
+	switch e.ValueType {
+	case "long", "float", "date", "boolean":
+	default:
+		return
+	}
+	if t.Wildcard || t.Regexp || t.Fuzzy != "" {
+		*errs = append(*errs, &Error{Col: t.vpos, Msg: fmt.Sprintf("字段 %s 为 %s 类型，不支持通配符、正则或模糊匹配",
+			field, e.ValueType)})
+		return
+	}
+	if msg := checkValue(e, field, t.Value); msg != "" {
+		*errs = append(*errs, &Error{Col: t.vpos, Msg: msg})
+	}
+}
+
+func checkRange(errs *ErrorList, e *logdb.RepoSchemaEntry, field string, r *Range) {
+	if e.ValueType == "boolean" {
+		*errs = append(*errs, &Error{Col: r.pos, Msg: fmt.Sprintf("字段 %s 为 boolean 类型，不支持范围查询", field)})
+		return
+	}
+	ok := true
+	for _, b := range []struct {
+		v   string
+		pos int
+	}{{r.Lo, r.loPos}, {r.Hi, r.hiPos}} {
+		if b.v == "*" {
+			continue
+		}
+		if msg := checkValue(e, field, b.v); msg != "" {
+			*errs = append(*errs, &Error{Col: b.pos, Msg: msg})
+			ok = false
+		}
+	}
+	if !ok || r.Lo == "*" || r.Hi == "*" {
+		return
+	}
+	switch e.ValueType {
+	case "long", "float":
+		lo, _ := strconv.ParseFloat(r.Lo, 64)
+		hi, _ := strconv.ParseFloat(r.Hi, 64)
+		if lo > hi {
+			*errs = append(*errs, &Error{Col: r.loPos, Msg: fmt.Sprintf("范围的下界 %s 大于上界 %s", r.Lo, r.Hi)})
+		}
+	case "date":
+		lo, err1 := dateBound(r.Lo)
+		hi, err2 := dateBound(r.Hi)
+		if err1 == nil && err2 == nil && lo.After(hi) {
+			*errs = append(*errs, &Error{Col: r.loPos, Msg: fmt.Sprintf("范围的下界 %s 晚于上界 %s", r.Lo, r.Hi)})
+		}
+	}
+}
+
+// checkValue 检查值是否符合字段类型，不符合时返回错误信息
+func checkValue(e *logdb.RepoSchemaEntry, field string, v string) string {
+	switch e.ValueType {
+	case "long":
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Sprintf("字段 %s 为 long 类型，%s 不是整数", field, quoted(v))
+		}
+	case "float":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Sprintf("字段 %s 为 float 类型，%s 不是数字", field, quoted(v))
+		}
+	case "boolean":
+		if v != "true" && v != "false" {
+			return fmt.Sprintf("字段 %s 为 boolean 类型，值应为 true 或 false", field)
+		}
+	case "date":
+		if strings.HasPrefix(v, "now") {
+			if _, err := DateMath(v, time.Now().In(location)); err != nil {
+				return fmt.Sprintf("字段 %s 为 date 类型，无法识别相对时间 %s ，格式如 now-1h now-1d/d", field, quoted(v))
+			}
+			return ""
+		}
+		if _, err := ParseDate(v); err != nil {
+			return fmt.Sprintf("字段 %s 为 date 类型，无法识别时间 %s ，格式如 %s", field, quoted(v), DateLayouts[0])
+		}
+	}
+	return ""
+}
+
+// dateBound date 类型字段范围的边界，支持 now 开头的相对时间
+func dateBound(v string) (time.Time, error) {
+	if strings.HasPrefix(v, "now") {
+		return DateMath(v, time.Now().In(location))
+	}
+	return ParseDate(v)
+}
+
+// location 解析不带时区的时间及计算 now/d 等相对时间所用的时区
+var location = time.Local
+
+// SetLocation 设置解析不带时区的时间所用的时区，默认为本地时区
+func SetLocation(loc *time.Location) {
+	location = loc
+}
+
+// ParseDate 解析 DateLayouts 中的时间格式或毫秒时间戳
+func ParseDate(v string) (t time.Time, err error) {
+	if ms, err1 := strconv.ParseInt(v, 10, 64); err1 == nil {
+		return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), nil
+	}
+	for _, layout := range DateLayouts {
+		if t, err = time.ParseInLocation(layout, v, location); err == nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,332 @@
+// Package term 提供交互式命令行的行编辑：光标移动、历史记录和 tab 补全。
+// 终端不支持 raw 模式或输入不是终端时，退化为按行读取。
+package term
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const maxHistory = 1000
+
+// ErrInterrupt 输入时按下 Ctrl-C
+var ErrInterrupt = errors.New("interrupt")
+
+// Completer 根据当前行和光标位置返回候选项。
+// head 与 tail 为补全位置前后保持不变的内容，选中的候选项放在两者之间
+type Completer func(line string, pos int) (head string, completions []string, tail string)
+
+type Editor struct {
+	Complete Completer
+	in       *os.File
+	out      io.Writer
+	r        *bufio.Reader
+	history  []string
+	histFile string
+}
+
+// NewEditor 从 stdin 读取输入；histFile 不为空时从该文件加载历史记录，并将新的记录追加到文件
+func NewEditor(histFile string) *Editor {
+	e := &Editor{
+		in:       os.Stdin,
+		out:      os.Stdout,
+		r:        bufio.NewReader(os.Stdin),
+		histFile: histFile,
+	}
+	if histFile != "" {
+		if data, err := ioutil.ReadFile(histFile); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if strings.TrimSpace(line) != "" {
+					e.history = append(e.history, line)
+				}
+			}
+		}
+		if len(e.history) > maxHistory {
+			e.history = e.history[len(e.history)-maxHistory:]
+		}
+	}
+	return e
+}
+
+// AddHistory 添加一条历史记录，与上一条相同时忽略
+func (e *Editor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" || strings.Contains(line, "\n") {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+// Readline 显示提示符并读取一行。Ctrl-D 且当前行为空时返回 io.EOF ，Ctrl-C 返回 ErrInterrupt
+func (e *Editor) Readline(prompt string) (string, error) {
+	restore, err := makeRaw(int(e.in.Fd()))
+	if err != nil {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer restore()
+	s := &lineState{e: e, prompt: prompt, hist: len(e.history)}
+	s.refresh()
+	return s.edit()
+}
+
+// lineState 正在编辑的行
+type lineState struct {
+	e       *Editor
+	prompt  string
+	buf     []rune
+	pos     int
+	hist    int    // 正在浏览的历史记录，等于 len(history) 表示正在编辑的新行
+	saved   string // 浏览历史记录前正在编辑的内容
+	lastTab bool
+}
+
+func (s *lineState) edit() (string, error) {
+	for {
+		r, _, err := s.e.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		tab := false
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(s.e.out, "\r\n")
+			return string(s.buf), nil
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 2: // Ctrl-B
+			s.move(-1)
+		case 3: // Ctrl-C
+			fmt.Fprint(s.e.out, "^C\r\n")
+			return "", ErrInterrupt
+		case 4: // Ctrl-D
+			if len(s.buf) == 0 {
+				fmt.Fprint(s.e.out, "\r\n")
+				return "", io.EOF
+			}
+			s.delete()
+		case 5: // Ctrl-E
+			s.pos = len(s.buf)
+		case 6: // Ctrl-F
+			s.move(1)
+		case 8, 127: // Backspace
+			if s.pos > 0 {
+				s.pos--
+				s.delete()
+			}
+		case '\t':
+			tab = true
+			s.complete()
+		case 11: // Ctrl-K
+			s.buf = s.buf[:s.pos]
+		case 12: // Ctrl-L
+			fmt.Fprint(s.e.out, "\x1b[H\x1b[2J")
+		case 14: // Ctrl-N
+			s.browse(1)
+		case 16: // Ctrl-P
+			s.browse(-1)
+		case 21: // Ctrl-U
+			s.buf = append([]rune{}, s.buf[s.pos:]...)
+			s.pos = 0
+		case 23: // Ctrl-W
+			i := s.pos
+			for i > 0 && unicode.IsSpace(s.buf[i-1]) {
+				i--
+			}
+			for i > 0 && !unicode.IsSpace(s.buf[i-1]) {
+				i--
+			}
+			s.buf = append(s.buf[:i], s.buf[s.pos:]...)
+			s.pos = i
+		case 27: // ESC ，方向键等转义序列
+			s.escape()
+		default:
+			if unicode.IsPrint(r) {
+				s.insert(string(r))
+			}
+		}
+		s.lastTab = tab
+		s.refresh()
+	}
+}
+
+func (s *lineState) escape() {
+	r, _, err := s.e.r.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	r, _, err = s.e.r.ReadRune()
+	if err != nil {
+		return
+	}
+	switch r {
+	case 'A':
+		s.browse(-1)
+	case 'B':
+		s.browse(1)
+	case 'C':
+		s.move(1)
+	case 'D':
+		s.move(-1)
+	case 'H':
+		s.pos = 0
+	case 'F':
+		s.pos = len(s.buf)
+	default:
+		if r < '0' || r > '9' {
+			return
+		}
+		// 形如 ESC [ 3 ~ 的序列
+		code := r
+		for {
+			r, _, err = s.e.r.ReadRune()
+			if err != nil || r == '~' {
+				break
+			}
+			if r < '0' || r > '9' {
+				return
+			}
+		}
+		switch code {
+		case '1', '7':
+			s.pos = 0
+		case '4', '8':
+			s.pos = len(s.buf)
+		case '3':
+			s.delete()
+		}
+	}
+}
+
+func (s *lineState) move(n int) {
+	s.pos += n
+	if s.pos < 0 {
+		s.pos = 0
+	}
+	if s.pos > len(s.buf) {
+		s.pos = len(s.buf)
+	}
+}
+
+func (s *lineState) insert(text string) {
+	rs := []rune(text)
+	buf := make([]rune, 0, len(s.buf)+len(rs))
+	buf = append(buf, s.buf[:s.pos]...)
+	buf = append(buf, rs...)
+	s.buf = append(buf, s.buf[s.pos:]...)
+	s.pos += len(rs)
+}
+
+// delete 删除光标处的字符
+func (s *lineState) delete() {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+	}
+}
+
+func (s *lineState) browse(n int) {
+	history := s.e.history
+	i := s.hist + n
+	if i < 0 || i > len(history) {
+		return
+	}
+	if s.hist == len(history) {
+		s.saved = string(s.buf)
+	}
+	s.hist = i
+	if i == len(history) {
+		s.buf = []rune(s.saved)
+	} else {
+		s.buf = []rune(history[i])
+	}
+	s.pos = len(s.buf)
+}
+
+// complete 只有一个候选项时直接补全；多个时补全公共前缀，再次按 tab 时列出所有候选项
+func (s *lineState) complete() {
+	if s.e.Complete == nil {
+		return
+	}
+	head, completions, tail := s.e.Complete(string(s.buf), s.pos)
+	if len(completions) == 0 {
+		return
+	}
+	c := completions[0]
+	if len(completions) > 1 {
+		c = commonPrefix(completions)
+	}
+	s.buf = []rune(head + c + tail)
+	s.pos = len([]rune(head + c))
+	if len(completions) > 1 && s.lastTab {
+		fmt.Fprint(s.e.out, "\r\n"+strings.Join(completions, "  ")+"\r\n")
+	}
+}
+
+func commonPrefix(ss []string) string {
+	prefix := []rune(ss[0])
+	for _, s := range ss[1:] {
+		rs := []rune(s)
+		i := 0
+		for i < len(prefix) && i < len(rs) && prefix[i] == rs[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+// refresh 重新绘制当前行，并将光标移到编辑位置
+func (s *lineState) refresh() {
+	col := width(s.prompt) + width(string(s.buf[:s.pos]))
+	seq := "\r" + s.prompt + string(s.buf) + "\x1b[K\r"
+	if col > 0 {
+		seq += fmt.Sprintf("\x1b[%dC", col)
+	}
+	fmt.Fprint(s.e.out, seq)
+}
+
+// width 字符串在终端中的显示宽度，中日韩等全角字符占两列
+func width(str string) int {
+	n := 0
+	for _, r := range str {
+		switch {
+		case r < 0x20 || r == 0x7f:
+		case r >= 0x1100 && (r <= 0x115f || r == 0x2329 || r == 0x232a ||
+			(r >= 0x2e80 && r <= 0xa4cf && r != 0x303f) ||
+			(r >= 0xac00 && r <= 0xd7a3) ||
+			(r >= 0xf900 && r <= 0xfaff) ||
+			(r >= 0xfe30 && r <= 0xfe4f) ||
+			(r >= 0xff00 && r <= 0xff60) ||
+			(r >= 0xffe0 && r <= 0xffe6) ||
+			(r >= 0x20000 && r <= 0x3fffd)):
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
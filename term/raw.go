@@ -0,0 +1,47 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw 将终端设置为 raw 模式，逐字符读取、不回显；返回恢复原设置的函数
+func makeRaw(fd int) (restore func(), err error) {
+	var old syscall.Termios
+	if err = ioctl(fd, ioctlGetTermios, &old); err != nil {
+		return
+	}
+	raw := old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Cflag |= syscall.CS8
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err = ioctl(fd, ioctlSetTermios, &raw); err != nil {
+		return
+	}
+	return func() { ioctl(fd, ioctlSetTermios, &old) }, nil
+}
+
+// getSize 终端的列数和行数
+func getSize(fd int) (cols, rows int, err error) {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+func ioctl(fd int, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
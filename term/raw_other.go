@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package term
+
+import "errors"
+
+// 其它平台不支持 raw 模式，退化为按行读取，没有行编辑和补全
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw mode not supported")
+}
+
+func getSize(fd int) (cols, rows int, err error) {
+	return 0, 0, errors.New("terminal size not supported")
+}
@@ -0,0 +1,216 @@
+package term
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 特殊按键，普通字符的 Key.Code 为 0
+const (
+	KeyNone = iota
+	KeyEnter
+	KeyEsc
+	KeyTab
+	KeyBackspace
+	KeyDelete
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPgUp
+	KeyPgDn
+	KeyCtrlC
+)
+
+type Key struct {
+	Code int
+	Rune rune
+}
+
+// Screen 全屏界面：使用终端的备用屏幕，逐键读取输入，整屏重绘
+type Screen struct {
+	out     *bufio.Writer
+	r       *bufio.Reader
+	restore func()
+}
+
+// NewScreen 切换到备用屏幕并进入 raw 模式。stdin 或 stdout 不是终端时返回错误
+func NewScreen() (*Screen, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return nil, errors.New("ERROR: 需要在终端中运行")
+	}
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	s := &Screen{
+		out:     bufio.NewWriter(os.Stdout),
+		r:       bufio.NewReader(os.Stdin),
+		restore: restore,
+	}
+	// 备用屏幕，隐藏光标
+	s.out.WriteString("\x1b[?1049h\x1b[?25l")
+	s.out.Flush()
+	return s, nil
+}
+
+// Close 恢复终端设置，回到原来的屏幕
+func (s *Screen) Close() {
+	s.out.WriteString("\x1b[?25h\x1b[?1049l")
+	s.out.Flush()
+	s.restore()
+}
+
+// Size 终端的列数和行数，无法获取时返回 80x24
+func (s *Screen) Size() (cols, rows int) {
+	cols, rows, err := getSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 || rows <= 0 {
+		return 80, 24
+	}
+	return
+}
+
+// Draw 整屏重绘。每行按屏幕宽度截断，行中可包含颜色等控制序列
+func (s *Screen) Draw(lines []string) {
+	s.out.WriteString("\x1b[H")
+	_, rows := s.Size()
+	for i := 0; i < rows; i++ {
+		if i < len(lines) {
+			s.out.WriteString(lines[i])
+		}
+		s.out.WriteString("\x1b[0m\x1b[K")
+		if i < rows-1 {
+			s.out.WriteString("\r\n")
+		}
+	}
+	s.out.Flush()
+}
+
+// ReadKey 读取一个按键
+func (s *Screen) ReadKey() (Key, error) {
+	r, _, err := s.r.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+	switch r {
+	case '\r', '\n':
+		return Key{Code: KeyEnter}, nil
+	case '\t':
+		return Key{Code: KeyTab}, nil
+	case 8, 127:
+		return Key{Code: KeyBackspace}, nil
+	case 3:
+		return Key{Code: KeyCtrlC}, nil
+	case 27:
+		// 转义序列的字节是一起到达的，没有后续字节时为单独的 ESC 键
+		if s.r.Buffered() == 0 {
+			return Key{Code: KeyEsc}, nil
+		}
+		return s.escape()
+	}
+	return Key{Rune: r}, nil
+}
+
+func (s *Screen) escape() (Key, error) {
+	r, _, err := s.r.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+	if r != '[' && r != 'O' {
+		return Key{Code: KeyEsc}, nil
+	}
+	r, _, err = s.r.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+	switch r {
+	case 'A':
+		return Key{Code: KeyUp}, nil
+	case 'B':
+		return Key{Code: KeyDown}, nil
+	case 'C':
+		return Key{Code: KeyRight}, nil
+	case 'D':
+		return Key{Code: KeyLeft}, nil
+	case 'H':
+		return Key{Code: KeyHome}, nil
+	case 'F':
+		return Key{Code: KeyEnd}, nil
+	}
+	if r < '0' || r > '9' {
+		return Key{}, nil
+	}
+	// 形如 ESC [ 5 ~ 的序列
+	code := r
+	for {
+		r, _, err = s.r.ReadRune()
+		if err != nil {
+			return Key{}, err
+		}
+		if r == '~' {
+			break
+		}
+		if r < '0' || r > '9' {
+			return Key{}, nil
+		}
+	}
+	switch code {
+	case '1', '7':
+		return Key{Code: KeyHome}, nil
+	case '4', '8':
+		return Key{Code: KeyEnd}, nil
+	case '3':
+		return Key{Code: KeyDelete}, nil
+	case '5':
+		return Key{Code: KeyPgUp}, nil
+	case '6':
+		return Key{Code: KeyPgDn}, nil
+	}
+	return Key{}, nil
+}
+
+// Width 字符串在终端中的显示宽度
+func Width(s string) int {
+	return width(s)
+}
+
+// Fit 将字符串截断或用空格补齐到显示宽度 w ，截断时以 … 结尾
+func Fit(s string, w int) string {
+	if w <= 0 {
+		return ""
+	}
+	n := width(s)
+	if n <= w {
+		return s + strings.Repeat(" ", w-n)
+	}
+	var b bytes.Buffer
+	used := 0
+	for _, r := range s {
+		rw := width(string(r))
+		if used+rw > w-1 {
+			break
+		}
+		b.WriteRune(r)
+		used += rw
+	}
+	return b.String() + "…" + strings.Repeat(" ", w-1-used)
+}
+
+// Reverse 反色显示
+func Reverse(s string) string {
+	return fmt.Sprintf("\x1b[7m%s\x1b[0m", s)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}